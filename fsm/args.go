@@ -0,0 +1,31 @@
+package fsm
+
+import "fmt"
+
+// Get returns the value of key in args, ok is false if the key is absent or its value is not a T
+func Get[T any](args ArgsType, key string) (value T, ok bool) {
+	value, ok = args[key].(T)
+	return value, ok
+}
+
+// String returns the value of key in args if it is a string
+func (args ArgsType) String(key string) (string, bool) {
+	return Get[string](args, key)
+}
+
+// Int returns the value of key in args if it is an int
+func (args ArgsType) Int(key string) (int, bool) {
+	return Get[int](args, key)
+}
+
+// CallerInfo returns the value of ArgCallerInfo, formatted with %v if it is not a string
+func (args ArgsType) CallerInfo() string {
+	value, ok := args[ArgCallerInfo]
+	if !ok {
+		return ""
+	}
+	if callerInfo, ok := value.(string); ok {
+		return callerInfo
+	}
+	return fmt.Sprintf("%v", value)
+}
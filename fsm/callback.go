@@ -0,0 +1,40 @@
+package fsm
+
+import "fmt"
+
+// ErrorCallback is a Callback which can fail:
+//   - an error on a user-defined event or on ExitEvent aborts the transition, the State stays in From state
+//   - an error on EntryEvent leaves the State in To state, or moves it to the Fallback state of the transition
+//
+// In both cases SendEvent returns the error wrapped in a *CallbackError.
+type (
+	ErrorCallback  func(*State, EventType, ArgsType) error
+	ErrorCallbacks map[StateType]ErrorCallback
+)
+
+// CallbackError is returned by SendEvent when an ErrorCallback fails
+type CallbackError struct {
+	// State is the state whose callback failed
+	State StateType
+	// Event is the event passed to the callback, EntryEvent or ExitEvent for entry and exit callbacks
+	Event EventType
+	Err   error
+}
+
+func (e *CallbackError) Error() string {
+	return fmt.Sprintf("Callback of state[%s] failed on event[%s]: %v", e.State, e.Event, e.Err)
+}
+
+func (e *CallbackError) Unwrap() error {
+	return e.Err
+}
+
+// WithErrorCallbacks registers error-returning callbacks, a state cannot have both a Callback and an ErrorCallback
+func WithErrorCallbacks(callbacks ErrorCallbacks) Option {
+	return func(fsm *FSM) error {
+		for state, callback := range callbacks {
+			fsm.errorCallbacks[state] = callback
+		}
+		return nil
+	}
+}
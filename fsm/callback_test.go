@@ -0,0 +1,114 @@
+package fsm
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errSendNgap = errors.New("send NGAP message failed")
+
+func newErrorCallbackFSM(t *testing.T, failures map[StateType]EventType, clock Clock) *FSM {
+	callback := func(name StateType) ErrorCallback {
+		return func(state *State, event EventType, args ArgsType) error {
+			if failures[name] == event {
+				return errSendNgap
+			}
+			return nil
+		}
+	}
+
+	f, err := NewFSM(
+		Transitions{
+			{Event: Start, From: Idle, To: WaitingComplete, Fallback: Failed},
+			{Event: Complete, From: WaitingComplete, To: Completed},
+		},
+		nil,
+		nil,
+		WithErrorCallbacks(ErrorCallbacks{
+			Idle:            callback(Idle),
+			WaitingComplete: callback(WaitingComplete),
+			Completed:       callback(Completed),
+			Failed:          callback(Failed),
+		}),
+		WithStateTimers(StateTimers{
+			WaitingComplete: {Timeout: 6 * time.Second, Event: Expire},
+		}),
+		WithClock(clock),
+	)
+	require.NoError(t, err)
+	return f
+}
+
+func TestErrorCallbackAbortsTransition(t *testing.T) {
+	log := newLog()
+
+	// event callback failure
+	f := newErrorCallbackFSM(t, map[StateType]EventType{Idle: Start}, newFakeClock())
+	s := NewState(Idle)
+	err := f.SendEvent(s, Start, nil, log)
+	var callbackErr *CallbackError
+	require.True(t, errors.As(err, &callbackErr))
+	assert.Equal(t, Idle, callbackErr.State)
+	assert.Equal(t, Start, callbackErr.Event)
+	assert.ErrorIs(t, err, errSendNgap)
+	assert.Equal(t, Idle, s.Current())
+
+	// exit callback failure keeps the From state and its timer
+	clock := newFakeClock()
+	f = newErrorCallbackFSM(t, map[StateType]EventType{WaitingComplete: ExitEvent}, clock)
+	s = NewState(Idle)
+	require.NoError(t, f.SendEvent(s, Start, nil, log))
+	err = f.SendEvent(s, Complete, nil, log)
+	assert.EqualError(t, err, "Callback of state[WaitingComplete] failed on event[Exit event]: "+errSendNgap.Error())
+	assert.Equal(t, WaitingComplete, s.Current())
+	assert.Contains(t, s.timers, WaitingComplete)
+}
+
+func TestCallbackErrorMessage(t *testing.T) {
+	// the stack trace of an error of github.com/pkg/errors is not part of the message
+	err := &CallbackError{State: Idle, Event: Start, Err: pkgerrors.New("send NGAP message failed")}
+	assert.Equal(t, "Callback of state[Idle] failed on event[Start]: send NGAP message failed", err.Error())
+}
+
+func TestErrorCallbackEntryFallback(t *testing.T) {
+	log := newLog()
+	clock := newFakeClock()
+	f := newErrorCallbackFSM(t, map[StateType]EventType{WaitingComplete: EntryEvent}, clock)
+
+	s := NewState(Idle)
+	err := f.SendEvent(s, Start, nil, log)
+	var callbackErr *CallbackError
+	require.True(t, errors.As(err, &callbackErr))
+	assert.Equal(t, WaitingComplete, callbackErr.State)
+	assert.Equal(t, EntryEvent, callbackErr.Event)
+	assert.Equal(t, Failed, s.Current())
+
+	// the timer of the failed state is stopped
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, Failed, s.Current())
+}
+
+func TestErrorCallbackEntryWithoutFallback(t *testing.T) {
+	log := newLog()
+	f := newErrorCallbackFSM(t, map[StateType]EventType{Completed: EntryEvent}, newFakeClock())
+
+	s := NewState(Idle)
+	require.NoError(t, f.SendEvent(s, Start, nil, log))
+	assert.ErrorIs(t, f.SendEvent(s, Complete, nil, log), errSendNgap)
+	assert.Equal(t, Completed, s.Current())
+}
+
+func TestErrorCallbackDuplicate(t *testing.T) {
+	_, err := NewFSM(
+		Transitions{{Event: Start, From: Idle, To: Completed}},
+		Callbacks{Idle: func(*State, EventType, ArgsType) {}},
+		nil,
+		WithErrorCallbacks(ErrorCallbacks{Idle: func(*State, EventType, ArgsType) error { return nil }}),
+	)
+	assert.EqualError(t, err, "Duplicate callback: Idle")
+}
@@ -0,0 +1,67 @@
+package fsm
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+type contextKey int
+
+const (
+	loggerContextKey contextKey = iota
+	fieldsContextKey
+)
+
+// EventLogLevels sets the level of the log written when an event is handled
+type EventLogLevels map[EventType]logrus.Level
+
+// ContextWithLogger returns a copy of ctx carrying log, which is used by SendEventContext
+func ContextWithLogger(ctx context.Context, log *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerContextKey, log)
+}
+
+// ContextWithFields returns a copy of ctx carrying fields added to the logs of SendEventContext,
+// e.g. logger.FieldSupi or logger.FieldAmfUeNgapID. Fields already carried by ctx are kept.
+func ContextWithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	merged := logrus.Fields{}
+	if parent, ok := ctx.Value(fieldsContextKey).(logrus.Fields); ok {
+		for key, value := range parent {
+			merged[key] = value
+		}
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, fieldsContextKey, merged)
+}
+
+// loggerFromContext returns the logger carried by ctx with its fields,
+// or the standard logger of logrus if ctx has no logger
+func loggerFromContext(ctx context.Context) *logrus.Entry {
+	log, ok := ctx.Value(loggerContextKey).(*logrus.Entry)
+	if !ok || log == nil {
+		log = logrus.NewEntry(logrus.StandardLogger())
+	}
+	if fields, ok := ctx.Value(fieldsContextKey).(logrus.Fields); ok {
+		log = log.WithFields(fields)
+	}
+	return log.WithContext(ctx)
+}
+
+// WithEventLogLevels sets the log level of events, events not listed are logged at info level
+func WithEventLogLevels(levels EventLogLevels) Option {
+	return func(fsm *FSM) error {
+		for event, level := range levels {
+			fsm.logLevels[event] = level
+		}
+		return nil
+	}
+}
+
+func (fsm *FSM) logLevel(event EventType) logrus.Level {
+	if level, ok := fsm.logLevels[event]; ok {
+		return level
+	}
+	return logrus.InfoLevel
+}
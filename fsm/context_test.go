@@ -0,0 +1,84 @@
+package fsm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	logger_util "github.com/free5gc/util/logger"
+)
+
+func TestSendEventContext(t *testing.T) {
+	log, hook := test.NewNullLogger()
+	log.SetLevel(logrus.DebugLevel)
+
+	f, err := NewFSM(
+		Transitions{
+			{Event: Open, From: Closed, To: Opened},
+			{Event: Close, From: Opened, To: Closed},
+		},
+		nil,
+		nil,
+		WithEventLogLevels(EventLogLevels{Close: logrus.DebugLevel}),
+	)
+	require.NoError(t, err)
+
+	ctx := ContextWithLogger(context.Background(), log.WithField(logger_util.FieldCategory, "FSM"))
+	ctx = ContextWithFields(ctx, logrus.Fields{logger_util.FieldSupi: "imsi-208930000000001"})
+	ctx = ContextWithFields(ctx, logrus.Fields{logger_util.FieldAmfUeNgapID: int64(1)})
+
+	s := NewState(Closed)
+	require.NoError(t, f.SendEventContext(ctx, s, Open, ArgsType{ArgCallerInfo: 123}))
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	assert.Equal(t, logrus.InfoLevel, entry.Level)
+	assert.Equal(t, "[123] Handle event[Open], transition from [Closed] to [Opened]", entry.Message)
+	assert.Equal(t, logrus.Fields{
+		logger_util.FieldCategory:    "FSM",
+		logger_util.FieldSupi:        "imsi-208930000000001",
+		logger_util.FieldAmfUeNgapID: int64(1),
+	}, entry.Data)
+	assert.Equal(t, ctx, entry.Context)
+
+	require.NoError(t, f.SendEventContext(ctx, s, Close, nil))
+	assert.Equal(t, logrus.DebugLevel, hook.LastEntry().Level)
+
+	// without logger in the context
+	require.NoError(t, f.SendEventContext(context.Background(), s, Open, nil))
+	assert.Equal(t, Opened, s.Current())
+}
+
+func TestArgsAccessors(t *testing.T) {
+	args := ArgsType{
+		ArgCallerInfo: "AMF",
+		"retry":       2,
+		"ngapID":      int64(3),
+	}
+
+	callerInfo, ok := args.String(ArgCallerInfo)
+	assert.True(t, ok)
+	assert.Equal(t, "AMF", callerInfo)
+	assert.Equal(t, "AMF", args.CallerInfo())
+
+	retry, ok := args.Int("retry")
+	assert.True(t, ok)
+	assert.Equal(t, 2, retry)
+
+	_, ok = args.Int("ngapID")
+	assert.False(t, ok)
+	ngapID, ok := Get[int64](args, "ngapID")
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), ngapID)
+
+	_, ok = args.String("absent")
+	assert.False(t, ok)
+
+	var nilArgs ArgsType
+	assert.Equal(t, "", nilArgs.CallerInfo())
+	assert.Equal(t, "5", ArgsType{ArgCallerInfo: 5}.CallerInfo())
+}
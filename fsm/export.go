@@ -0,0 +1,276 @@
+package fsm
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	diagramFillColor    = "skyblue"
+	diagramCurrentColor = "orange"
+	guardedSuffix       = " [guarded]"
+)
+
+// stateTree is the nesting of all states of an FSM, with states and transitions sorted by name
+type stateTree struct {
+	roots       []StateType
+	children    map[StateType][]StateType
+	transitions Transitions
+	current     StateType
+}
+
+func newStateTree(fsm *FSM, current *State) *stateTree {
+	tree := &stateTree{children: make(map[StateType][]StateType)}
+	for state := range fsm.states() {
+		if parent, ok := fsm.parents[state]; ok {
+			tree.children[parent] = append(tree.children[parent], state)
+		} else {
+			tree.roots = append(tree.roots, state)
+		}
+	}
+	sortStates(tree.roots)
+	for _, children := range tree.children {
+		sortStates(children)
+	}
+
+	tree.transitions = fsm.allTransitions()
+	sort.SliceStable(tree.transitions, func(i, j int) bool {
+		ti, tj := tree.transitions[i], tree.transitions[j]
+		if ti.From != tj.From {
+			return ti.From < tj.From
+		}
+		if ti.Event != tj.Event {
+			return ti.Event < tj.Event
+		}
+		return ti.To < tj.To
+	})
+
+	if current != nil {
+		tree.current = current.Current()
+	}
+	return tree
+}
+
+// leaf returns the first non-composite state nested in state, or state itself if it is not composite
+func (tree *stateTree) leaf(state StateType) StateType {
+	for children, ok := tree.children[state]; ok; children, ok = tree.children[state] {
+		state = children[0]
+	}
+	return state
+}
+
+func transitionLabel(trans Transition) string {
+	if trans.Guard != nil {
+		return string(trans.Event) + guardedSuffix
+	}
+	return string(trans.Event)
+}
+
+// diagramID converts a state name into an identifier accepted by Mermaid and PlantUML
+func diagramID(state StateType) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, string(state))
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func writeString(w io.Writer, s string) error {
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// WriteDot writes fsm in Graphviz dot format, nested states are drawn as clusters.
+// current is optional, its current state is highlighted.
+func WriteDot(w io.Writer, fsm *FSM, current *State) error {
+	tree := newStateTree(fsm, current)
+	var b strings.Builder
+
+	b.WriteString("digraph FSM {\n")
+	b.WriteString("\trankdir=LR\n")
+	b.WriteString("\tsize=\"100\"\n")
+	b.WriteString("\tcompound=true\n")
+	fmt.Fprintf(&b, "\tnode[width=1 fixedsize=false shape=ellipse style=filled fillcolor=%q]\n", diagramFillColor)
+
+	var writeState func(state StateType, indent string)
+	writeState = func(state StateType, indent string) {
+		children, composite := tree.children[state]
+		if !composite {
+			fmt.Fprintf(&b, "%s%s", indent, dotQuote(string(state)))
+			if state == tree.current {
+				fmt.Fprintf(&b, " [fillcolor=%q]", diagramCurrentColor)
+			}
+			b.WriteString("\n")
+			return
+		}
+		fmt.Fprintf(&b, "%ssubgraph %s {\n", indent, dotQuote("cluster_"+string(state)))
+		fmt.Fprintf(&b, "%s\tlabel=%s\n", indent, dotQuote(string(state)))
+		if state == tree.current {
+			fmt.Fprintf(&b, "%s\tstyle=filled\n%s\tfillcolor=%q\n", indent, indent, diagramCurrentColor)
+		}
+		for _, child := range children {
+			writeState(child, indent+"\t")
+		}
+		fmt.Fprintf(&b, "%s}\n", indent)
+	}
+	for _, root := range tree.roots {
+		writeState(root, "\t")
+	}
+
+	for _, trans := range tree.transitions {
+		attrs := []string{"label=" + dotQuote(transitionLabel(trans))}
+		if _, ok := tree.children[trans.From]; ok {
+			attrs = append(attrs, "ltail="+dotQuote("cluster_"+string(trans.From)))
+		}
+		if _, ok := tree.children[trans.To]; ok {
+			attrs = append(attrs, "lhead="+dotQuote("cluster_"+string(trans.To)))
+		}
+		fmt.Fprintf(&b, "\t%s -> %s [%s]\n", dotQuote(string(tree.leaf(trans.From))),
+			dotQuote(string(tree.leaf(trans.To))), strings.Join(attrs, " "))
+	}
+
+	b.WriteString("}\n")
+	return writeString(w, b.String())
+}
+
+// WriteMermaid writes fsm as a Mermaid stateDiagram-v2.
+// current is optional, its current state is highlighted.
+func WriteMermaid(w io.Writer, fsm *FSM, current *State) error {
+	tree := newStateTree(fsm, current)
+	var b strings.Builder
+
+	b.WriteString("stateDiagram-v2\n")
+
+	var writeState func(state StateType, indent string)
+	writeState = func(state StateType, indent string) {
+		id := diagramID(state)
+		children, composite := tree.children[state]
+		if id != string(state) {
+			fmt.Fprintf(&b, "%sstate %q as %s\n", indent, string(state), id)
+		} else if !composite {
+			fmt.Fprintf(&b, "%s%s\n", indent, id)
+		}
+		if !composite {
+			return
+		}
+		fmt.Fprintf(&b, "%sstate %s {\n", indent, id)
+		for _, child := range children {
+			writeState(child, indent+"    ")
+		}
+		fmt.Fprintf(&b, "%s}\n", indent)
+	}
+	for _, root := range tree.roots {
+		writeState(root, "    ")
+	}
+
+	for _, trans := range tree.transitions {
+		fmt.Fprintf(&b, "    %s --> %s : %s\n", diagramID(trans.From), diagramID(trans.To), transitionLabel(trans))
+	}
+
+	if tree.current != "" {
+		fmt.Fprintf(&b, "    classDef current fill:%s\n", diagramCurrentColor)
+		fmt.Fprintf(&b, "    class %s current\n", diagramID(tree.current))
+	}
+	return writeString(w, b.String())
+}
+
+// WritePlantUML writes fsm as a PlantUML state diagram.
+// current is optional, its current state is highlighted.
+func WritePlantUML(w io.Writer, fsm *FSM, current *State) error {
+	tree := newStateTree(fsm, current)
+	var b strings.Builder
+
+	b.WriteString("@startuml\n")
+
+	var writeState func(state StateType, indent string)
+	writeState = func(state StateType, indent string) {
+		id := diagramID(state)
+		decl := "state " + id
+		if id != string(state) {
+			decl = fmt.Sprintf("state %q as %s", string(state), id)
+		}
+		if state == tree.current {
+			decl += " #" + diagramCurrentColor
+		}
+
+		children, composite := tree.children[state]
+		if !composite {
+			fmt.Fprintf(&b, "%s%s\n", indent, decl)
+			return
+		}
+		fmt.Fprintf(&b, "%s%s {\n", indent, decl)
+		for _, child := range children {
+			writeState(child, indent+"  ")
+		}
+		fmt.Fprintf(&b, "%s}\n", indent)
+	}
+	for _, root := range tree.roots {
+		writeState(root, "")
+	}
+
+	for _, trans := range tree.transitions {
+		fmt.Fprintf(&b, "%s --> %s : %s\n", diagramID(trans.From), diagramID(trans.To), transitionLabel(trans))
+	}
+
+	b.WriteString("@enduml\n")
+	return writeString(w, b.String())
+}
+
+// WriteSCXML writes fsm as a W3C SCXML document.
+// current is optional, its current state is written as the initial state of the document.
+// Guards cannot be expressed in SCXML, guarded transitions are written without condition.
+func WriteSCXML(w io.Writer, fsm *FSM, current *State) error {
+	tree := newStateTree(fsm, current)
+	var b strings.Builder
+
+	outgoing := make(map[StateType]Transitions)
+	for _, trans := range tree.transitions {
+		outgoing[trans.From] = append(outgoing[trans.From], trans)
+	}
+
+	b.WriteString(xml.Header)
+	b.WriteString(`<scxml xmlns="http://www.w3.org/2005/07/scxml" version="1.0"`)
+	if tree.current != "" {
+		fmt.Fprintf(&b, ` initial="%s"`, xmlEscape(string(tree.current)))
+	}
+	b.WriteString(">\n")
+
+	var writeState func(state StateType, indent string)
+	writeState = func(state StateType, indent string) {
+		fmt.Fprintf(&b, "%s<state id=\"%s\">\n", indent, xmlEscape(string(state)))
+		for _, trans := range outgoing[state] {
+			// an internal transition has no target, so that no state is exited or entered
+			if trans.From == trans.To {
+				fmt.Fprintf(&b, "%s  <transition event=\"%s\"/>\n", indent, xmlEscape(string(trans.Event)))
+				continue
+			}
+			fmt.Fprintf(&b, "%s  <transition event=\"%s\" target=\"%s\"/>\n",
+				indent, xmlEscape(string(trans.Event)), xmlEscape(string(trans.To)))
+		}
+		for _, child := range tree.children[state] {
+			writeState(child, indent+"  ")
+		}
+		fmt.Fprintf(&b, "%s</state>\n", indent)
+	}
+	for _, root := range tree.roots {
+		writeState(root, "  ")
+	}
+
+	b.WriteString("</scxml>\n")
+	return writeString(w, b.String())
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	// strings.Builder never returns an error
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
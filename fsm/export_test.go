@@ -0,0 +1,128 @@
+package fsm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteDot(t *testing.T) {
+	var trace []string
+	f := newHierarchicalFSM(t, &trace)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteDot(&buf, f, NewState(Idle)))
+	assert.Equal(t, `digraph FSM {
+	rankdir=LR
+	size="100"
+	compound=true
+	node[width=1 fixedsize=false shape=ellipse style=filled fillcolor="skyblue"]
+	"Deregistered"
+	subgraph "cluster_Registered" {
+		label="Registered"
+		"Connected"
+		"Idle" [fillcolor="orange"]
+	}
+	"Connected" -> "Idle" [label="Release"]
+	"Deregistered" -> "Idle" [label="Register"]
+	"Idle" -> "Connected" [label="Connect"]
+	"Connected" -> "Deregistered" [label="Deregister" ltail="cluster_Registered"]
+	"Connected" -> "Connected" [label="Refresh" ltail="cluster_Registered" lhead="cluster_Registered"]
+}
+`, buf.String())
+
+	// output is deterministic
+	for i := 0; i < 10; i++ {
+		var again bytes.Buffer
+		require.NoError(t, WriteDot(&again, f, NewState(Idle)))
+		assert.Equal(t, buf.String(), again.String())
+	}
+}
+
+func TestWriteMermaid(t *testing.T) {
+	var trace []string
+	f := newHierarchicalFSM(t, &trace)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteMermaid(&buf, f, NewState(Connected)))
+	assert.Equal(t, `stateDiagram-v2
+    Deregistered
+    state Registered {
+        Connected
+        Idle
+    }
+    Connected --> Idle : Release
+    Deregistered --> Idle : Register
+    Idle --> Connected : Connect
+    Registered --> Deregistered : Deregister
+    Registered --> Registered : Refresh
+    classDef current fill:orange
+    class Connected current
+`, buf.String())
+}
+
+func TestWritePlantUML(t *testing.T) {
+	f, err := NewFSM(
+		Transitions{
+			{Event: Open, From: Closed, To: "Half opened", Guard: func(*State, ArgsType) bool { return true }},
+			{Event: Open, From: "Half opened", To: Opened},
+		},
+		nil,
+		nil,
+	)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePlantUML(&buf, f, nil))
+	assert.Equal(t, `@startuml
+state Closed
+state "Half opened" as Half_opened
+state Opened
+Closed --> Half_opened : Open [guarded]
+Half_opened --> Opened : Open
+@enduml
+`, buf.String())
+}
+
+func TestWriteSCXML(t *testing.T) {
+	var trace []string
+	f := newHierarchicalFSM(t, &trace)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteSCXML(&buf, f, NewState(Deregistered)))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<scxml xmlns="http://www.w3.org/2005/07/scxml" version="1.0" initial="Deregistered">
+  <state id="Deregistered">
+    <transition event="Register" target="Idle"/>
+  </state>
+  <state id="Registered">
+    <transition event="Deregister" target="Deregistered"/>
+    <transition event="Refresh"/>
+    <state id="Connected">
+      <transition event="Release" target="Idle"/>
+    </state>
+    <state id="Idle">
+      <transition event="Connect" target="Connected"/>
+    </state>
+  </state>
+</scxml>
+`, buf.String())
+}
+
+func TestExportDot(t *testing.T) {
+	var trace []string
+	f := newHierarchicalFSM(t, &trace)
+
+	outfile := filepath.Join(t.TempDir(), "fsm")
+	require.NoError(t, ExportDot(f, outfile))
+
+	data, err := os.ReadFile(outfile + ".dot")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, WriteDot(&buf, f, nil))
+	assert.Equal(t, buf.String(), string(data))
+}
@@ -1,12 +1,15 @@
 package fsm
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+
+	"github.com/free5gc/util/internal/clock"
 )
 
 type (
@@ -26,10 +29,17 @@ type (
 // Transition defines a transition
 // that a Event is triggered at From state,
 // and transfer to To state after the Event
+//
+// Several transitions may share the same Event and From if they are guarded,
+// the first one (in declaration order) whose Guard accepts the event is taken.
 type Transition struct {
 	Event EventType
 	From  StateType
 	To    StateType
+	// Guard is optional, a transition without Guard is always taken
+	Guard Guard
+	// Fallback is optional, the state to move to if an entry callback fails, see ErrorCallback
+	Fallback StateType
 }
 
 type Transitions []Transition
@@ -49,39 +59,76 @@ type (
 	metricFunc func(string, string)
 )
 
-var stateTransitionMetricFunc metricFunc
-
 type FSM struct {
-	// transitions stores one transition for each event
-	transitions map[eventKey]Transition
+	// transitions stores candidate transitions for each event, in declaration order
+	transitions map[eventKey][]Transition
 	// callbacks stores one callback function for one state
 	callbacks map[StateType]Callback
+	// errorCallbacks stores one error-returning callback function for one state
+	errorCallbacks map[StateType]ErrorCallback
+	// parents stores the parent state of each nested state
+	parents map[StateType]StateType
+	// timers stores the timer started on entering a state
+	timers map[StateType]StateTimer
+	// clock drives the state timers
+	clock Clock
+	// validateOpts is set by WithValidation
+	validateOpts *ValidateOptions
+	// metricFunc is called with the from and to states of each transition
+	metricFunc metricFunc
+	// metrics and machine are set by WithMetrics
+	metrics *Metrics
+	machine string
+	// logLevels stores the log level of events, see WithEventLogLevels
+	logLevels map[EventType]logrus.Level
 }
 
+// Option configures an optional feature of FSM, see NewFSM
+type Option func(*FSM) error
+
 // NewFSM create a new FSM object then registers transitions and callbacks to it
 func NewFSM(
 	transitions Transitions,
 	callbacks Callbacks,
 	callbackMetricFunc metricFunc,
+	opts ...Option,
 ) (*FSM, error) {
 	fsm := &FSM{
-		transitions: make(map[eventKey]Transition),
-		callbacks:   make(map[StateType]Callback),
+		transitions:    make(map[eventKey][]Transition),
+		callbacks:      make(map[StateType]Callback),
+		errorCallbacks: make(map[StateType]ErrorCallback),
+		parents:        make(map[StateType]StateType),
+		timers:         make(map[StateType]StateTimer),
+		clock:          clock.Real{},
+		logLevels:      make(map[EventType]logrus.Level),
+	}
+
+	for _, opt := range opts {
+		if err := opt(fsm); err != nil {
+			return nil, err
+		}
 	}
 
 	allStates := make(map[StateType]bool)
+	for child, parent := range fsm.parents {
+		allStates[child] = true
+		allStates[parent] = true
+	}
 
 	for _, transition := range transitions {
 		key := eventKey{
 			Event: transition.Event,
 			From:  transition.From,
 		}
-		if _, ok := fsm.transitions[key]; ok {
+		if fsm.hasUnguarded(key) {
 			return nil, errors.Errorf("Duplicate transition: %+v", transition)
 		} else {
-			fsm.transitions[key] = transition
+			fsm.transitions[key] = append(fsm.transitions[key], transition)
 			allStates[transition.From] = true
 			allStates[transition.To] = true
+			if transition.Fallback != "" {
+				allStates[transition.Fallback] = true
+			}
 		}
 	}
 
@@ -93,10 +140,27 @@ func NewFSM(
 		}
 	}
 
-	if callbackMetricFunc != nil {
-		stateTransitionMetricFunc = callbackMetricFunc
+	for state := range fsm.errorCallbacks {
+		if _, ok := allStates[state]; !ok {
+			return nil, errors.Errorf("Unknown state: %+v", state)
+		}
+		if _, ok := fsm.callbacks[state]; ok {
+			return nil, errors.Errorf("Duplicate callback: %+v", state)
+		}
 	}
 
+	for state := range fsm.timers {
+		if _, ok := allStates[state]; !ok {
+			return nil, errors.Errorf("Unknown state: %+v", state)
+		}
+	}
+
+	if err := fsm.validate(); err != nil {
+		return nil, err
+	}
+
+	fsm.metricFunc = callbackMetricFunc
+
 	return fsm, nil
 }
 
@@ -105,58 +169,164 @@ func NewFSM(
 //   - on exit callback: call when fsm leave one state, with ExitEvent event
 //   - event callback: call when user trigger a user-defined event
 //   - on entry callback: call when fsm enter one state, with EntryEvent event
+//
+// For nested states, an event unhandled by the current state bubbles up to its
+// ancestors, and exit/entry callbacks are fired for every state left or entered
+// on the way to the target state (see WithParents).
+// State timers are stopped before exit callbacks and started before entry callbacks.
+//
+// If the State has a running mailbox (see State.StartMailbox), the event is queued
+// and SendEvent waits until it is processed, so it must not be called from callbacks
+// of the same State, use PostEvent instead, which never blocks.
 func (fsm *FSM) SendEvent(state *State, event EventType, args ArgsType, log *logrus.Entry) error {
-	key := eventKey{
-		From:  state.Current(),
-		Event: event,
+	return fsm.SendEventContext(ContextWithLogger(context.Background(), log), state, event, args)
+}
+
+// SendEventContext is SendEvent with the logger and log fields carried by ctx,
+// see ContextWithLogger and ContextWithFields
+func (fsm *FSM) SendEventContext(ctx context.Context, state *State, event EventType, args ArgsType) error {
+	return fsm.dispatch(state, &envelope{
+		ctx:   ctx,
+		fsm:   fsm,
+		state: state,
+		event: event,
+		args:  args,
+	})
+}
+
+// handleEvent processes one event on the State, see SendEvent.
+// timer is the expired timer of a timeout event, nil for other events.
+func (fsm *FSM) handleEvent(
+	ctx context.Context,
+	state *State,
+	event EventType,
+	args ArgsType,
+	timer *expiringTimer,
+) (err error) {
+	current, ok := state.currentIfRunning(timer)
+	if !ok {
+		// the timer has been stopped or restarted since it expired
+		return nil
 	}
+	defer func() {
+		fsm.recordHistory(state, event, current, args, err)
+	}()
 
-	if trans, ok := fsm.transitions[key]; ok {
-		callerInfo := ""
-		if argCallerInfo, ok2 := args[ArgCallerInfo]; ok2 {
-			callerInfo = fmt.Sprintf("[%s] ", argCallerInfo.(string))
-		}
+	trans, err := fsm.lookup(state, current, event, args)
+	if err != nil {
+		return err
+	}
 
-		log.Infof("%sHandle event[%s], transition from [%s] to [%s]",
-			callerInfo, event, trans.From, trans.To)
+	log := loggerFromContext(ctx)
+	callerInfo := ""
+	if argCallerInfo := args.CallerInfo(); argCallerInfo != "" {
+		callerInfo = fmt.Sprintf("[%s] ", argCallerInfo)
+	}
 
-		// event callback
-		fsm.callbacks[trans.From](state, event, args)
+	log.Logf(fsm.logLevel(event), "%sHandle event[%s], transition from [%s] to [%s]",
+		callerInfo, event, current, trans.To)
 
-		// exit callback
-		if trans.From != trans.To {
-			if stateTransitionMetricFunc != nil {
-				stateTransitionMetricFunc(string(trans.From), string(trans.To))
+	// event callback
+	if err = fsm.callback(trans.From, state, event, args); err != nil {
+		return err
+	}
+
+	// an internal transition (From == To) neither leaves nor enters any state
+	if trans.From == trans.To {
+		return nil
+	}
+
+	if err = fsm.transit(ctx, state, event, current, trans.To, args); err != nil {
+		var callbackErr *CallbackError
+		if trans.Fallback != "" && errors.As(err, &callbackErr) && callbackErr.Event == EntryEvent {
+			log.Warnf("%sEnter state[%s] failed, fallback to [%s]: %+v", callerInfo, trans.To, trans.Fallback, err)
+			if fallbackErr := fsm.transit(ctx, state, event, trans.To, trans.Fallback, args); fallbackErr != nil {
+				log.Errorf("%sFallback to [%s] failed: %+v", callerInfo, trans.Fallback, fallbackErr)
 			}
-			fsm.callbacks[trans.From](state, ExitEvent, args)
 		}
+		return err
+	}
+	return nil
+}
+
+// transit leaves from state and enters to state.
+// If an exit callback fails, the State stays in from state and the timers of exited states are restarted;
+// if an entry callback fails, the State is already in to state and the remaining entry callbacks are skipped.
+func (fsm *FSM) transit(
+	ctx context.Context,
+	state *State,
+	event EventType,
+	from, to StateType,
+	args ArgsType,
+) error {
+	exits, entries := fsm.transitionPath(from, to)
 
-		// entry callback
-		if trans.From != trans.To {
-			state.Set(trans.To)
-			fsm.callbacks[trans.To](state, EntryEvent, args)
+	// exit callbacks, from the innermost state
+	for i, exit := range exits {
+		fsm.stopTimer(state, exit)
+		if err := fsm.callback(exit, state, ExitEvent, args); err != nil {
+			for _, exited := range exits[:i+1] {
+				fsm.startTimer(ctx, state, exited)
+			}
+			return err
+		}
+	}
+
+	if from != to {
+		fsm.setState(state, event, from, to)
+	}
+
+	// entry callbacks, from the outermost state
+	for _, entry := range entries {
+		fsm.startTimer(ctx, state, entry)
+		if err := fsm.callback(entry, state, EntryEvent, args); err != nil {
+			return err
 		}
-		return nil
-	} else {
-		return errors.Errorf("Unknown transition[From: %s, Event: %s]", state.Current(), event)
 	}
+	return nil
 }
 
-// ExportDot export fsm in dot format to outfile, which can be visualize by graphviz
-func ExportDot(fsm *FSM, outfile string) error {
-	dot := `digraph FSM {
-	rankdir=LR
-	size="100"
-    node[width=1 fixedsize=false shape=ellipse style=filled fillcolor="skyblue"]
-	`
+// lookup finds the transition handling event at current state,
+// searching from current state up to its outermost ancestor.
+// It returns a *GuardRejectedError if transitions exist but all their guards reject the event.
+func (fsm *FSM) lookup(state *State, current StateType, event EventType, args ArgsType) (Transition, error) {
+	rejected := false
+	for _, from := range fsm.lineage(current) {
+		candidates, ok := fsm.transitions[eventKey{Event: event, From: from}]
+		if !ok {
+			continue
+		}
+		for _, trans := range candidates {
+			if trans.Guard == nil || trans.Guard(state, args) {
+				return trans, nil
+			}
+		}
+		rejected = true
+	}
 
-	for _, trans := range fsm.transitions {
-		link := fmt.Sprintf("\t%s -> %s [label=\"%s\"]", trans.From, trans.To, trans.Event)
-		dot = dot + "\r\n" + link
+	if rejected {
+		return Transition{}, &GuardRejectedError{From: current, Event: event}
 	}
+	return Transition{}, errors.Errorf("Unknown transition[From: %s, Event: %s]", current, event)
+}
 
-	dot = dot + "\r\n}\n"
+// callback invokes the callback of state if it has one,
+// the error of an ErrorCallback is wrapped in a *CallbackError
+func (fsm *FSM) callback(target StateType, state *State, event EventType, args ArgsType) error {
+	if callback, ok := fsm.errorCallbacks[target]; ok {
+		if err := callback(state, event, args); err != nil {
+			return &CallbackError{State: target, Event: event, Err: err}
+		}
+		return nil
+	}
+	if callback, ok := fsm.callbacks[target]; ok {
+		callback(state, event, args)
+	}
+	return nil
+}
 
+// ExportDot export fsm in dot format to outfile, which can be visualize by graphviz, see WriteDot
+func ExportDot(fsm *FSM, outfile string) error {
 	if !strings.HasSuffix(outfile, ".dot") {
 		outfile = fmt.Sprintf("%s.dot", outfile)
 	}
@@ -164,7 +334,7 @@ func ExportDot(fsm *FSM, outfile string) error {
 	if file, err := os.Create(outfile); err != nil {
 		return err
 	} else {
-		if _, err = file.WriteString(dot); err != nil {
+		if err = WriteDot(file, fsm, nil); err != nil {
 			return err
 		}
 		fmt.Printf("Output the FSM to \"%s\"\n", outfile)
@@ -0,0 +1,37 @@
+package fsm
+
+import "fmt"
+
+// Guard decides whether a transition is allowed to fire for the current State and event arguments
+type Guard func(*State, ArgsType) bool
+
+// GuardRejectedError is returned by SendEvent when the event has transitions
+// from the current state but all of their guards reject it
+type GuardRejectedError struct {
+	From  StateType
+	Event EventType
+}
+
+func (e *GuardRejectedError) Error() string {
+	return fmt.Sprintf("Transition rejected by guard[From: %s, Event: %s]", e.From, e.Event)
+}
+
+// hasUnguarded return true if key already has a transition without guard,
+// any transition declared after it would never be taken
+func (fsm *FSM) hasUnguarded(key eventKey) bool {
+	for _, trans := range fsm.transitions[key] {
+		if trans.Guard == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// allTransitions returns all registered transitions
+func (fsm *FSM) allTransitions() Transitions {
+	var transitions Transitions
+	for _, candidates := range fsm.transitions {
+		transitions = append(transitions, candidates...)
+	}
+	return transitions
+}
@@ -0,0 +1,101 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	Authenticating StateType = "Authenticating"
+	Authenticated  StateType = "Authenticated"
+	Rejected       StateType = "Rejected"
+)
+
+const (
+	AuthSuccess EventType = "AuthSuccess"
+	AuthFailure EventType = "AuthFailure"
+)
+
+const argRetry = "Retry"
+
+func TestGuardedTransitions(t *testing.T) {
+	log := newLog()
+	retryBelow := func(limit int) Guard {
+		return func(state *State, args ArgsType) bool {
+			retry, ok := args[argRetry].(int)
+			return ok && retry < limit
+		}
+	}
+
+	f, err := NewFSM(
+		Transitions{
+			{Event: AuthSuccess, From: Authenticating, To: Authenticated, Guard: retryBelow(3)},
+			{Event: AuthFailure, From: Authenticating, To: Authenticating, Guard: retryBelow(3)},
+			{Event: AuthFailure, From: Authenticating, To: Rejected},
+		},
+		Callbacks{
+			Authenticating: func(state *State, event EventType, args ArgsType) {},
+			Authenticated:  func(state *State, event EventType, args ArgsType) {},
+			Rejected:       func(state *State, event EventType, args ArgsType) {},
+		},
+		nil,
+	)
+	require.NoError(t, err)
+
+	s := NewState(Authenticating)
+
+	// first passing guard is taken
+	require.NoError(t, f.SendEvent(s, AuthFailure, ArgsType{argRetry: 1}, log))
+	assert.Equal(t, Authenticating, s.Current())
+
+	// all guards reject
+	err = f.SendEvent(s, AuthSuccess, ArgsType{argRetry: 3}, log)
+	var guardErr *GuardRejectedError
+	require.True(t, errors.As(err, &guardErr))
+	assert.Equal(t, Authenticating, guardErr.From)
+	assert.Equal(t, AuthSuccess, guardErr.Event)
+	assert.Equal(t, Authenticating, s.Current())
+
+	// unguarded transition is the fallback
+	require.NoError(t, f.SendEvent(s, AuthFailure, ArgsType{argRetry: 3}, log))
+	assert.Equal(t, Rejected, s.Current())
+}
+
+func TestGuardBubblesToParent(t *testing.T) {
+	log := newLog()
+	allow := false
+	f, err := NewFSM(
+		Transitions{
+			{Event: Deregister, From: Idle, To: Deregistered, Guard: func(*State, ArgsType) bool { return allow }},
+			{Event: Deregister, From: Registered, To: Deregistered},
+		},
+		nil,
+		nil,
+		WithParents(Parents{Idle: Registered}),
+	)
+	require.NoError(t, err)
+
+	s := NewState(Idle)
+	require.NoError(t, f.SendEvent(s, Deregister, nil, log))
+	assert.Equal(t, Deregistered, s.Current())
+}
+
+func TestGuardedDuplicateTransition(t *testing.T) {
+	unguarded := Transition{Event: AuthFailure, From: Authenticating, To: Rejected}
+	_, err := NewFSM(
+		Transitions{
+			unguarded,
+			{Event: AuthFailure, From: Authenticating, To: Authenticating, Guard: func(*State, ArgsType) bool {
+				return true
+			}},
+		},
+		nil,
+		nil,
+	)
+	assert.ErrorContains(t, err, fmt.Sprintf("Duplicate transition: {Event:%s From:%s To:%s",
+		AuthFailure, Authenticating, Authenticating))
+}
@@ -0,0 +1,81 @@
+package fsm
+
+import "github.com/pkg/errors"
+
+// Parents maps a nested state to its parent state
+type Parents map[StateType]StateType
+
+// WithParents nests states into parent states.
+// An event which is not handled by the current state is passed to its parent,
+// so a transition shared by all children only needs to be declared once on the parent.
+func WithParents(parents Parents) Option {
+	return func(fsm *FSM) error {
+		for child, parent := range parents {
+			if child == parent {
+				return errors.Errorf("State cannot be its own parent: %+v", child)
+			}
+			fsm.parents[child] = parent
+		}
+
+		for child := range fsm.parents {
+			visited := map[StateType]bool{child: true}
+			for parent, ok := fsm.parents[child]; ok; parent, ok = fsm.parents[parent] {
+				if visited[parent] {
+					return errors.Errorf("Cyclic state hierarchy: %+v", child)
+				}
+				visited[parent] = true
+			}
+		}
+		return nil
+	}
+}
+
+// Parent returns the parent state of state, ok is false if state is not nested
+func (fsm *FSM) Parent(state StateType) (parent StateType, ok bool) {
+	parent, ok = fsm.parents[state]
+	return parent, ok
+}
+
+// IsIn return true if the current state is target or nested in target
+func (fsm *FSM) IsIn(state *State, target StateType) bool {
+	for _, s := range fsm.lineage(state.Current()) {
+		if s == target {
+			return true
+		}
+	}
+	return false
+}
+
+// lineage returns state followed by all its ancestors, from the innermost to the outermost
+func (fsm *FSM) lineage(state StateType) []StateType {
+	lineage := []StateType{state}
+	for parent, ok := fsm.parents[state]; ok; parent, ok = fsm.parents[parent] {
+		lineage = append(lineage, parent)
+	}
+	return lineage
+}
+
+// transitionPath returns the states to exit (from the innermost) and the states to enter
+// (from the outermost) when moving from one state to another,
+// the common ancestors of both states are neither exited nor entered
+func (fsm *FSM) transitionPath(from, to StateType) (exits, entries []StateType) {
+	toLineage := fsm.lineage(to)
+	common := make(map[StateType]int, len(toLineage))
+	for i, s := range toLineage {
+		common[s] = i
+	}
+
+	enterDepth := len(toLineage)
+	for _, s := range fsm.lineage(from) {
+		if i, ok := common[s]; ok {
+			enterDepth = i
+			break
+		}
+		exits = append(exits, s)
+	}
+
+	for i := enterDepth - 1; i >= 0; i-- {
+		entries = append(entries, toLineage[i])
+	}
+	return exits, entries
+}
@@ -0,0 +1,129 @@
+package fsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	Deregistered StateType = "Deregistered"
+	Registered   StateType = "Registered"
+	Idle         StateType = "Idle"
+	Connected    StateType = "Connected"
+)
+
+const (
+	Register   EventType = "Register"
+	Deregister EventType = "Deregister"
+	Connect    EventType = "Connect"
+	Release    EventType = "Release"
+	Refresh    EventType = "Refresh"
+)
+
+func newHierarchicalFSM(t *testing.T, trace *[]string) *FSM {
+	record := func(state *State, event EventType, args ArgsType) {
+		*trace = append(*trace, fmt.Sprintf("%s:%s", state.Current(), event))
+	}
+	recordOf := func(name StateType) Callback {
+		return func(state *State, event EventType, args ArgsType) {
+			*trace = append(*trace, fmt.Sprintf("%s:%s", name, event))
+		}
+	}
+
+	f, err := NewFSM(
+		Transitions{
+			{Event: Register, From: Deregistered, To: Idle},
+			{Event: Connect, From: Idle, To: Connected},
+			{Event: Release, From: Connected, To: Idle},
+			{Event: Deregister, From: Registered, To: Deregistered},
+			{Event: Refresh, From: Registered, To: Registered},
+		},
+		Callbacks{
+			Deregistered: recordOf(Deregistered),
+			Registered:   recordOf(Registered),
+			Idle:         record,
+			Connected:    record,
+		},
+		nil,
+		WithParents(Parents{
+			Idle:      Registered,
+			Connected: Registered,
+		}),
+	)
+	require.NoError(t, err)
+	return f
+}
+
+func TestHierarchicalFSM(t *testing.T) {
+	log := newLog()
+	var trace []string
+	f := newHierarchicalFSM(t, &trace)
+	s := NewState(Deregistered)
+
+	require.NoError(t, f.SendEvent(s, Register, nil, log))
+	assert.Equal(t, Idle, s.Current())
+	assert.Equal(t, []string{
+		"Deregistered:Register",
+		"Deregistered:Exit event",
+		"Registered:Entry event",
+		"Idle:Entry event",
+	}, trace)
+
+	// sibling transition does not leave the parent state
+	trace = nil
+	require.NoError(t, f.SendEvent(s, Connect, nil, log))
+	assert.Equal(t, Connected, s.Current())
+	assert.Equal(t, []string{
+		"Idle:Connect",
+		"Idle:Exit event",
+		"Connected:Entry event",
+	}, trace)
+	assert.True(t, f.IsIn(s, Registered))
+	assert.True(t, f.IsIn(s, Connected))
+	assert.False(t, f.IsIn(s, Idle))
+
+	// internal transition of the parent keeps the nested state
+	trace = nil
+	require.NoError(t, f.SendEvent(s, Refresh, nil, log))
+	assert.Equal(t, Connected, s.Current())
+	assert.Equal(t, []string{"Registered:Refresh"}, trace)
+
+	// unhandled event bubbles up to the parent
+	trace = nil
+	require.NoError(t, f.SendEvent(s, Deregister, nil, log))
+	assert.Equal(t, Deregistered, s.Current())
+	assert.Equal(t, []string{
+		"Registered:Deregister",
+		"Connected:Exit event",
+		"Registered:Exit event",
+		"Deregistered:Entry event",
+	}, trace)
+
+	assert.EqualError(t, f.SendEvent(s, Connect, nil, log),
+		fmt.Sprintf("Unknown transition[From: %s, Event: %s]", Deregistered, Connect))
+}
+
+func TestHierarchicalFSMInitFail(t *testing.T) {
+	_, err := NewFSM(
+		Transitions{{Event: Connect, From: Idle, To: Connected}},
+		nil,
+		nil,
+		WithParents(Parents{
+			Idle:       Registered,
+			Registered: Connected,
+			Connected:  Idle,
+		}),
+	)
+	assert.ErrorContains(t, err, "Cyclic state hierarchy")
+
+	_, err = NewFSM(
+		Transitions{{Event: Connect, From: Idle, To: Connected}},
+		nil,
+		nil,
+		WithParents(Parents{Idle: Idle}),
+	)
+	assert.EqualError(t, err, fmt.Sprintf("State cannot be its own parent: %+v", Idle))
+}
@@ -0,0 +1,142 @@
+package fsm
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// TransitionRecord records one event handled by a State
+type TransitionRecord struct {
+	Time       time.Time `json:"time" bson:"time"`
+	Event      EventType `json:"event" bson:"event"`
+	From       StateType `json:"from" bson:"from"`
+	To         StateType `json:"to" bson:"to"`
+	CallerInfo string    `json:"callerInfo,omitempty" bson:"callerInfo,omitempty"`
+	// Error is the error returned by SendEvent, empty on success
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// history is a ring buffer of the latest TransitionRecords
+type history struct {
+	records []TransitionRecord
+	next    int
+	full    bool
+}
+
+func (h *history) add(record TransitionRecord) {
+	h.records[h.next] = record
+	h.next = (h.next + 1) % len(h.records)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+func (h *history) list() []TransitionRecord {
+	if !h.full {
+		return append([]TransitionRecord(nil), h.records[:h.next]...)
+	}
+	return append(append([]TransitionRecord(nil), h.records[h.next:]...), h.records[:h.next]...)
+}
+
+// EnableHistory makes the State keep its latest size handled events, the oldest ones are dropped first.
+// A size <= 0 disables the history.
+func (state *State) EnableHistory(size int) {
+	state.stateMutex.Lock()
+	defer state.stateMutex.Unlock()
+
+	if size <= 0 {
+		state.history = nil
+		return
+	}
+	state.history = &history{records: make([]TransitionRecord, size)}
+}
+
+// History returns the recorded events of the State, from the oldest to the latest
+func (state *State) History() []TransitionRecord {
+	state.stateMutex.RLock()
+	defer state.stateMutex.RUnlock()
+
+	if state.history == nil {
+		return nil
+	}
+	return state.history.list()
+}
+
+// MarshalHistory returns the recorded events of the State in JSON
+func (state *State) MarshalHistory() ([]byte, error) {
+	return json.Marshal(state.History())
+}
+
+func (fsm *FSM) recordHistory(state *State, event EventType, from StateType, args ArgsType, err error) {
+	state.stateMutex.Lock()
+	defer state.stateMutex.Unlock()
+
+	if state.history == nil {
+		return
+	}
+
+	record := TransitionRecord{
+		Time:       fsm.clock.Now(),
+		Event:      event,
+		From:       from,
+		To:         state.current,
+		CallerInfo: args.CallerInfo(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	state.history.add(record)
+}
+
+// Replay sends the recorded events to a new State starting from the From state of the first record,
+// and checks that every event leads to the same state and error as recorded.
+// argsOf is optional and provides the event arguments of each record, e.g. for guards.
+// It returns the State as it is after the last replayed record, and an error at the first divergence.
+// State timers started during the replay are stopped before Replay returns.
+func Replay(
+	fsm *FSM,
+	records []TransitionRecord,
+	argsOf func(TransitionRecord) ArgsType,
+	log *logrus.Entry,
+) (*State, error) {
+	if len(records) == 0 {
+		return nil, errors.New("Replay: no record")
+	}
+
+	state := NewState(records[0].From)
+	defer state.StopTimers()
+	for i, record := range records {
+		if current := state.Current(); current != record.From {
+			return state, errors.Errorf("Replay record[%d]: expect from [%s] but state is [%s]",
+				i, record.From, current)
+		}
+
+		var args ArgsType
+		if argsOf != nil {
+			args = argsOf(record)
+		}
+		if args == nil {
+			args = ArgsType{}
+		}
+		if record.CallerInfo != "" {
+			args[ArgCallerInfo] = record.CallerInfo
+		}
+
+		errStr := ""
+		if err := fsm.SendEvent(state, record.Event, args, log); err != nil {
+			errStr = err.Error()
+		}
+		if errStr != record.Error {
+			return state, errors.Errorf("Replay record[%d]: expect error [%s] but got [%s]",
+				i, record.Error, errStr)
+		}
+		if current := state.Current(); current != record.To {
+			return state, errors.Errorf("Replay record[%d]: expect to [%s] but state is [%s]",
+				i, record.To, current)
+		}
+	}
+	return state, nil
+}
@@ -0,0 +1,75 @@
+package fsm
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateHistory(t *testing.T) {
+	log := newLog()
+	clock := newFakeClock()
+	f := newTimerFSM(t, clock)
+
+	s := NewState(Idle)
+	assert.Nil(t, s.History())
+
+	s.EnableHistory(3)
+	require.NoError(t, f.SendEvent(s, Start, ArgsType{ArgCallerInfo: "UE-1"}, log))
+	clock.Advance(6 * time.Second)
+	require.Error(t, f.SendEvent(s, Complete, nil, log))
+
+	records := s.History()
+	require.Len(t, records, 3)
+	assert.Equal(t, TransitionRecord{
+		Time: clock.Now().Add(-6 * time.Second), Event: Start, From: Idle, To: WaitingComplete, CallerInfo: "UE-1",
+	}, records[0])
+	assert.Equal(t, TransitionRecord{
+		Time: clock.Now(), Event: Expire, From: WaitingComplete, To: Failed, CallerInfo: timerCallerInfo,
+	}, records[1])
+	assert.Equal(t, TransitionRecord{
+		Time: clock.Now(), Event: Complete, From: Failed, To: Failed,
+		Error: "Unknown transition[From: Failed, Event: Complete]",
+	}, records[2])
+
+	// the oldest record is dropped
+	require.Error(t, f.SendEvent(s, Start, nil, log))
+	records = s.History()
+	require.Len(t, records, 3)
+	assert.Equal(t, Expire, records[0].Event)
+	assert.Equal(t, Start, records[2].Event)
+
+	data, err := s.MarshalHistory()
+	require.NoError(t, err)
+	var decoded []TransitionRecord
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, records, decoded)
+}
+
+func TestReplay(t *testing.T) {
+	log := newLog()
+	clock := newFakeClock()
+	f := newTimerFSM(t, clock)
+
+	s := NewState(Idle)
+	s.EnableHistory(10)
+	require.NoError(t, f.SendEvent(s, Start, nil, log))
+	clock.Advance(6 * time.Second)
+	require.Error(t, f.SendEvent(s, Complete, nil, log))
+
+	replayed, err := Replay(f, s.History(), nil, log)
+	require.NoError(t, err)
+	assert.Equal(t, Failed, replayed.Current())
+
+	// diverged history
+	records := s.History()
+	records[1].To = Completed
+	_, err = Replay(f, records, nil, log)
+	assert.EqualError(t, err, "Replay record[1]: expect to [Completed] but state is [Failed]")
+
+	_, err = Replay(f, nil, nil, log)
+	assert.EqualError(t, err, "Replay: no record")
+}
@@ -0,0 +1,205 @@
+package fsm
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrStateStopped is returned for events sent to a State whose mailbox has been stopped
+var ErrStateStopped = errors.New("State mailbox is stopped")
+
+// Future is the result of an event posted by PostEvent
+type Future struct {
+	done chan struct{}
+	err  error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) complete(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// Done returns a channel which is closed when the event has been processed
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the event has been processed and returns the error of SendEvent
+func (f *Future) Wait() error {
+	<-f.done
+	return f.err
+}
+
+// envelope is an event waiting to be processed
+type envelope struct {
+	ctx   context.Context
+	fsm   *FSM
+	state *State
+	event EventType
+	args  ArgsType
+	// timer is set for a timeout event, see expireTimer
+	timer *expiringTimer
+}
+
+func (env *envelope) process() error {
+	return env.fsm.handleEvent(env.ctx, env.state, env.event, env.args, env.timer)
+}
+
+// mailbox is the ordered event queue of a State
+type mailbox struct {
+	mtx sync.Mutex
+	// notEmpty is signaled when an event is queued or the mailbox is stopped,
+	// notFull when an event is taken out of the queue
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	queue    []*queuedEvent
+	// size is the number of queued events above which SendEvent waits, PostEvent never waits
+	size    int
+	stopped bool
+	done    chan struct{}
+}
+
+type queuedEvent struct {
+	*envelope
+	future *Future
+}
+
+// StartMailbox makes the State process its events one at a time, in the order they are sent,
+// by a dedicated goroutine. SendEvent waits while size events are queued, PostEvent never waits
+// so it can be called from callbacks of the same State.
+// It does nothing if the mailbox has already been started.
+func (state *State) StartMailbox(size int) {
+	state.stateMutex.Lock()
+	defer state.stateMutex.Unlock()
+
+	if state.mailbox != nil {
+		return
+	}
+
+	mb := &mailbox{
+		size: size,
+		done: make(chan struct{}),
+	}
+	mb.notEmpty = sync.NewCond(&mb.mtx)
+	mb.notFull = sync.NewCond(&mb.mtx)
+	state.mailbox = mb
+
+	go func() {
+		defer close(mb.done)
+		for {
+			queued, ok := mb.receive()
+			if !ok {
+				return
+			}
+			queued.future.complete(queued.process())
+		}
+	}()
+}
+
+// send queues an event, waiting for room in the queue if wait is true.
+// It returns false if the mailbox is stopped.
+func (mb *mailbox) send(queued *queuedEvent, wait bool) bool {
+	mb.mtx.Lock()
+	defer mb.mtx.Unlock()
+
+	for wait && !mb.stopped && len(mb.queue) > 0 && len(mb.queue) >= mb.size {
+		mb.notFull.Wait()
+	}
+	if mb.stopped {
+		return false
+	}
+	mb.queue = append(mb.queue, queued)
+	mb.notEmpty.Signal()
+	return true
+}
+
+// receive takes the first queued event, ok is false if the mailbox is stopped and empty
+func (mb *mailbox) receive() (queued *queuedEvent, ok bool) {
+	mb.mtx.Lock()
+	defer mb.mtx.Unlock()
+
+	for len(mb.queue) == 0 {
+		if mb.stopped {
+			return nil, false
+		}
+		mb.notEmpty.Wait()
+	}
+	queued = mb.queue[0]
+	mb.queue[0] = nil
+	mb.queue = mb.queue[1:]
+	mb.notFull.Broadcast()
+	return queued, true
+}
+
+func (mb *mailbox) stop() {
+	mb.mtx.Lock()
+	defer mb.mtx.Unlock()
+
+	mb.stopped = true
+	mb.notEmpty.Broadcast()
+	mb.notFull.Broadcast()
+}
+
+// Stop stops the mailbox of the State after all pending events have been processed,
+// events sent afterwards fail with ErrStateStopped.
+// It must not be called from callbacks of the same State.
+func (state *State) Stop() {
+	mb := state.getMailbox()
+	if mb == nil {
+		return
+	}
+	mb.stop()
+	<-mb.done
+}
+
+func (state *State) getMailbox() *mailbox {
+	state.stateMutex.RLock()
+	defer state.stateMutex.RUnlock()
+	return state.mailbox
+}
+
+// PostEvent queues an event to the mailbox of the State and returns without waiting for it to be processed,
+// the queue grows beyond the size of the mailbox if needed.
+// If the State has no mailbox, the event is processed before PostEvent returns.
+func (fsm *FSM) PostEvent(state *State, event EventType, args ArgsType, log *logrus.Entry) *Future {
+	return fsm.PostEventContext(ContextWithLogger(context.Background(), log), state, event, args)
+}
+
+// PostEventContext is PostEvent with the logger and log fields carried by ctx
+func (fsm *FSM) PostEventContext(ctx context.Context, state *State, event EventType, args ArgsType) *Future {
+	return fsm.post(state, &envelope{
+		ctx:   ctx,
+		fsm:   fsm,
+		state: state,
+		event: event,
+		args:  args,
+	}, false)
+}
+
+// post processes env directly or queues it to the mailbox of the State, see mailbox.send for wait
+func (fsm *FSM) post(state *State, env *envelope, wait bool) *Future {
+	future := newFuture()
+
+	mb := state.getMailbox()
+	if mb == nil {
+		future.complete(env.process())
+		return future
+	}
+
+	if !mb.send(&queuedEvent{envelope: env, future: future}, wait) {
+		future.complete(ErrStateStopped)
+	}
+	return future
+}
+
+// dispatch processes env directly or through the mailbox of the State, and waits for the result
+func (fsm *FSM) dispatch(state *State, env *envelope) error {
+	return fsm.post(state, env, true).Wait()
+}
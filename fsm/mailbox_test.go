@@ -0,0 +1,145 @@
+package fsm
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newToggleFSM(t *testing.T, inCallback *int32, overlapped *int32, handled *int32) *FSM {
+	callback := func(state *State, event EventType, args ArgsType) {
+		if event == EntryEvent || event == ExitEvent {
+			return
+		}
+		if atomic.AddInt32(inCallback, 1) > 1 {
+			atomic.StoreInt32(overlapped, 1)
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(handled, 1)
+		atomic.AddInt32(inCallback, -1)
+	}
+
+	f, err := NewFSM(
+		Transitions{
+			{Event: Open, From: Closed, To: Opened},
+			{Event: Close, From: Opened, To: Closed},
+			{Event: Open, From: Opened, To: Opened},
+			{Event: Close, From: Closed, To: Closed},
+		},
+		Callbacks{
+			Opened: callback,
+			Closed: callback,
+		},
+		nil,
+	)
+	require.NoError(t, err)
+	return f
+}
+
+func TestMailboxSerializesEvents(t *testing.T) {
+	log := newLog()
+	var inCallback, overlapped, handled int32
+	f := newToggleFSM(t, &inCallback, &overlapped, &handled)
+
+	s := NewState(Closed)
+	s.StartMailbox(4)
+	defer s.Stop()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			event := Open
+			if i%2 == 0 {
+				event = Close
+			}
+			assert.NoError(t, f.SendEvent(s, event, nil, log))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(10), atomic.LoadInt32(&handled))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&overlapped))
+}
+
+func TestMailboxPostEventAndStop(t *testing.T) {
+	log := newLog()
+	var inCallback, overlapped, handled int32
+	f := newToggleFSM(t, &inCallback, &overlapped, &handled)
+
+	s := NewState(Closed)
+	s.StartMailbox(16)
+
+	var futures []*Future
+	for i := 0; i < 5; i++ {
+		futures = append(futures, f.PostEvent(s, Open, nil, log))
+	}
+	futures = append(futures, f.PostEvent(s, EventType("fake event"), nil, log))
+
+	// Stop drains pending events
+	s.Stop()
+	assert.Equal(t, int32(5), atomic.LoadInt32(&handled))
+	for _, future := range futures[:5] {
+		<-future.Done()
+		assert.NoError(t, future.Wait())
+	}
+	assert.EqualError(t, futures[5].Wait(), "Unknown transition[From: Opened, Event: fake event]")
+	assert.Equal(t, Opened, s.Current())
+
+	assert.ErrorIs(t, f.SendEvent(s, Close, nil, log), ErrStateStopped)
+	assert.ErrorIs(t, f.PostEvent(s, Close, nil, log).Wait(), ErrStateStopped)
+	assert.Equal(t, Opened, s.Current())
+}
+
+func TestPostEventWithoutMailbox(t *testing.T) {
+	log := newLog()
+	var inCallback, overlapped, handled int32
+	f := newToggleFSM(t, &inCallback, &overlapped, &handled)
+
+	s := NewState(Closed)
+	future := f.PostEvent(s, Open, nil, log)
+	assert.NoError(t, future.Wait())
+	assert.Equal(t, Opened, s.Current())
+}
+
+func TestMailboxPostEventFromCallback(t *testing.T) {
+	log := newLog()
+	var f *FSM
+	var opened int32
+	f, err := NewFSM(
+		Transitions{
+			{Event: Open, From: Closed, To: Opened},
+			{Event: Open, From: Opened, To: Opened},
+			{Event: Close, From: Opened, To: Closed},
+		},
+		Callbacks{
+			Closed: func(state *State, event EventType, args ArgsType) {},
+			Opened: func(state *State, event EventType, args ArgsType) {
+				switch event {
+				case EntryEvent:
+					// the queue is full while posting from the mailbox goroutine
+					for i := 0; i < 3; i++ {
+						f.PostEvent(state, Open, nil, log)
+					}
+					f.PostEvent(state, Close, nil, log)
+				case Open:
+					atomic.AddInt32(&opened, 1)
+				}
+			},
+		},
+		nil,
+	)
+	require.NoError(t, err)
+
+	s := NewState(Closed)
+	s.StartMailbox(0)
+	require.NoError(t, f.SendEvent(s, Open, nil, log))
+	s.Stop()
+	assert.Equal(t, int32(3), atomic.LoadInt32(&opened))
+	assert.Equal(t, Closed, s.Current())
+}
@@ -0,0 +1,136 @@
+package fsm
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const (
+	METRICS_SUBSYSTEM_NAME = "fsm"
+
+	TRANSITION_COUNTER_NAME = "transitions_total"
+	TRANSITION_COUNTER_DESC = "Total number of state transitions"
+
+	TIME_IN_STATE_HIST_NAME = "time_in_state_seconds"
+	TIME_IN_STATE_HIST_DESC = "Histogram of the time spent in a state before leaving it"
+
+	STATES_GAUGE_NAME = "states"
+	STATES_GAUGE_DESC = "Number of tracked States currently in each state"
+)
+
+// metric collectors label names
+const (
+	MACHINE_LABEL = "machine"
+	FROM_LABEL    = "from"
+	TO_LABEL      = "to"
+	EVENT_LABEL   = "event"
+	STATE_LABEL   = "state"
+)
+
+// Metrics holds the Prometheus collectors of FSMs,
+// one Metrics can be shared by several FSMs registered with different machine names
+type Metrics struct {
+	transitionCounter *prometheus.CounterVec
+	timeInState       *prometheus.HistogramVec
+	statesGauge       *prometheus.GaugeVec
+}
+
+// NewMetrics creates the FSM collectors, they must be registered with Collectors,
+// e.g. as custom collectors of metrics.InitMetrics
+func NewMetrics(namespace string) *Metrics {
+	return &Metrics{
+		transitionCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: METRICS_SUBSYSTEM_NAME,
+				Name:      TRANSITION_COUNTER_NAME,
+				Help:      TRANSITION_COUNTER_DESC,
+			},
+			[]string{MACHINE_LABEL, FROM_LABEL, TO_LABEL, EVENT_LABEL},
+		),
+		timeInState: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Subsystem: METRICS_SUBSYSTEM_NAME,
+				Name:      TIME_IN_STATE_HIST_NAME,
+				Help:      TIME_IN_STATE_HIST_DESC,
+				Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+			},
+			[]string{MACHINE_LABEL, STATE_LABEL},
+		),
+		statesGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: METRICS_SUBSYSTEM_NAME,
+				Name:      STATES_GAUGE_NAME,
+				Help:      STATES_GAUGE_DESC,
+			},
+			[]string{MACHINE_LABEL, STATE_LABEL},
+		),
+	}
+}
+
+// Collectors returns the collectors to register
+func (m *Metrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.transitionCounter, m.timeInState, m.statesGauge}
+}
+
+// WithMetrics reports the transitions of FSM to m, labeled with machine
+func WithMetrics(m *Metrics, machine string) Option {
+	return func(fsm *FSM) error {
+		fsm.metrics = m
+		fsm.machine = machine
+		return nil
+	}
+}
+
+// TrackState counts the State in the states gauge of FSM until UntrackState is called,
+// the time spent in its current state is measured from now on
+func (fsm *FSM) TrackState(state *State) {
+	state.stateMutex.Lock()
+	defer state.stateMutex.Unlock()
+
+	if fsm.metrics == nil || state.trackedBy == fsm {
+		return
+	}
+	state.trackedBy = fsm
+	state.enteredAt = fsm.clock.Now()
+	fsm.metrics.statesGauge.WithLabelValues(fsm.machine, string(state.current)).Inc()
+}
+
+// UntrackState stops counting the State in the states gauge of FSM, e.g. when the UE context is released
+func (fsm *FSM) UntrackState(state *State) {
+	state.stateMutex.Lock()
+	defer state.stateMutex.Unlock()
+
+	if fsm.metrics == nil || state.trackedBy != fsm {
+		return
+	}
+	state.trackedBy = nil
+	fsm.metrics.statesGauge.WithLabelValues(fsm.machine, string(state.current)).Dec()
+}
+
+// setState sets the State to the to state of a transition and reports it
+// to the metric function and Metrics of FSM
+func (fsm *FSM) setState(state *State, event EventType, from, to StateType) {
+	if fsm.metricFunc != nil {
+		fsm.metricFunc(string(from), string(to))
+	}
+
+	state.stateMutex.Lock()
+	defer state.stateMutex.Unlock()
+
+	state.current = to
+	if fsm.metrics == nil {
+		return
+	}
+
+	now := fsm.clock.Now()
+	fsm.metrics.transitionCounter.WithLabelValues(fsm.machine, string(from), string(to), string(event)).Inc()
+	if !state.enteredAt.IsZero() {
+		fsm.metrics.timeInState.WithLabelValues(fsm.machine, string(from)).
+			Observe(now.Sub(state.enteredAt).Seconds())
+	}
+	state.enteredAt = now
+	if state.trackedBy == fsm {
+		fsm.metrics.statesGauge.WithLabelValues(fsm.machine, string(from)).Dec()
+		fsm.metrics.statesGauge.WithLabelValues(fsm.machine, string(to)).Inc()
+	}
+}
@@ -0,0 +1,83 @@
+package fsm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/util/metrics/utils"
+)
+
+func TestMetrics(t *testing.T) {
+	log := newLog()
+	clock := newFakeClock()
+	m := NewMetrics("test")
+
+	reg := prometheus.NewRegistry()
+	for _, collector := range m.Collectors() {
+		require.NoError(t, reg.Register(collector))
+	}
+
+	var transitions [][2]string
+	newMachine := func(machine string) *FSM {
+		f, err := NewFSM(
+			Transitions{
+				{Event: Open, From: Closed, To: Opened},
+				{Event: Close, From: Opened, To: Closed},
+			},
+			nil,
+			func(from, to string) {
+				transitions = append(transitions, [2]string{machine, from})
+			},
+			WithMetrics(m, machine),
+			WithClock(clock),
+		)
+		require.NoError(t, err)
+		return f
+	}
+	amf := newMachine("amf")
+	smf := newMachine("smf")
+
+	s1, s2 := NewState(Closed), NewState(Closed)
+	amf.TrackState(s1)
+	amf.TrackState(s2)
+	assert.Equal(t, 2.0, gaugeValue(t, m, "amf", Closed))
+
+	clock.Advance(2 * time.Second)
+	require.NoError(t, amf.SendEvent(s1, Open, nil, log))
+	require.NoError(t, smf.SendEvent(NewState(Closed), Open, nil, log))
+
+	// each FSM keeps its own metric function
+	assert.Equal(t, [][2]string{{"amf", "Closed"}, {"smf", "Closed"}}, transitions)
+
+	value, err := utils.GetCounterVecValue("transitions", m.transitionCounter, prometheus.Labels{
+		MACHINE_LABEL: "amf", FROM_LABEL: "Closed", TO_LABEL: "Opened", EVENT_LABEL: "Open",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, value)
+
+	assert.Equal(t, 1.0, gaugeValue(t, m, "amf", Closed))
+	assert.Equal(t, 1.0, gaugeValue(t, m, "amf", Opened))
+	assert.Equal(t, 0.0, gaugeValue(t, m, "smf", Opened))
+
+	metric := &dto.Metric{}
+	observer, err := m.timeInState.GetMetricWithLabelValues("amf", string(Closed))
+	require.NoError(t, err)
+	require.NoError(t, observer.(prometheus.Histogram).Write(metric))
+	assert.Equal(t, uint64(1), metric.GetHistogram().GetSampleCount())
+	assert.Equal(t, 2.0, metric.GetHistogram().GetSampleSum())
+
+	amf.UntrackState(s1)
+	amf.UntrackState(s1)
+	assert.Equal(t, 0.0, gaugeValue(t, m, "amf", Opened))
+}
+
+func gaugeValue(t *testing.T, m *Metrics, machine string, state StateType) float64 {
+	metric := &dto.Metric{}
+	require.NoError(t, m.statesGauge.WithLabelValues(machine, string(state)).Write(metric))
+	return metric.GetGauge().GetValue()
+}
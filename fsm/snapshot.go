@@ -0,0 +1,108 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// StateSnapshotVersion is the schema version of marshaled States,
+// it is increased whenever the format changes in an incompatible way
+const StateSnapshotVersion = 1
+
+// stateSnapshot is the persisted form of a State,
+// timers and mailbox are not persisted, they are restarted by FSM.Restore
+type stateSnapshot struct {
+	Version     int                `json:"version" bson:"version"`
+	Current     StateType          `json:"current" bson:"current"`
+	HistorySize int                `json:"historySize,omitempty" bson:"historySize,omitempty"`
+	History     []TransitionRecord `json:"history,omitempty" bson:"history,omitempty"`
+}
+
+func (state *State) snapshot() stateSnapshot {
+	state.stateMutex.RLock()
+	defer state.stateMutex.RUnlock()
+
+	snapshot := stateSnapshot{
+		Version: StateSnapshotVersion,
+		Current: state.current,
+	}
+	if state.history != nil {
+		snapshot.HistorySize = len(state.history.records)
+		snapshot.History = state.history.list()
+	}
+	return snapshot
+}
+
+func (state *State) restore(snapshot stateSnapshot) error {
+	if snapshot.Version != StateSnapshotVersion {
+		return errors.Errorf("Unsupported State snapshot version: %d", snapshot.Version)
+	}
+	if snapshot.Current == "" {
+		return errors.New("Empty state in State snapshot")
+	}
+
+	var h *history
+	if snapshot.HistorySize > 0 {
+		h = &history{records: make([]TransitionRecord, snapshot.HistorySize)}
+		for _, record := range snapshot.History {
+			h.add(record)
+		}
+	}
+
+	state.stateMutex.Lock()
+	defer state.stateMutex.Unlock()
+	state.current = snapshot.Current
+	state.history = h
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, the current state and history are kept
+func (state *State) MarshalJSON() ([]byte, error) {
+	return json.Marshal(state.snapshot())
+}
+
+// UnmarshalJSON implements json.Unmarshaler, use FSM.Restore before sending events to the State
+func (state *State) UnmarshalJSON(data []byte) error {
+	var snapshot stateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	return state.restore(snapshot)
+}
+
+// MarshalBSON implements bson.Marshaler, so that a State can be stored with mongoapi
+func (state *State) MarshalBSON() ([]byte, error) {
+	return bson.Marshal(state.snapshot())
+}
+
+// UnmarshalBSON implements bson.Unmarshaler, use FSM.Restore before sending events to the State
+func (state *State) UnmarshalBSON(data []byte) error {
+	var snapshot stateSnapshot
+	if err := bson.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	return state.restore(snapshot)
+}
+
+// Restore re-enters the current state of an unmarshaled State, e.g. after an NF restart:
+// the state timers of the current state and its ancestors are started again,
+// but no EntryEvent callback is called.
+// The timeout events are sent with the values of ctx, see SendEventContext.
+func (fsm *FSM) Restore(ctx context.Context, state *State) error {
+	current := state.Current()
+	if !fsm.states()[current] {
+		return errors.Errorf("Unknown state: %+v", current)
+	}
+
+	state.stateMutex.Lock()
+	state.enteredAt = fsm.clock.Now()
+	state.stateMutex.Unlock()
+
+	for _, s := range fsm.lineage(current) {
+		fsm.startTimer(ctx, state, s)
+	}
+	return nil
+}
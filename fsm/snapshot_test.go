@@ -0,0 +1,98 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type ueContext struct {
+	Supi  string `json:"supi" bson:"supi"`
+	State *State `json:"state" bson:"state"`
+}
+
+func TestStateSnapshotJSON(t *testing.T) {
+	log := newLog()
+	f := newTimerFSM(t, newFakeClock())
+
+	s := NewState(Idle)
+	s.EnableHistory(4)
+	require.NoError(t, f.SendEvent(s, Start, nil, log))
+
+	data, err := json.Marshal(ueContext{Supi: "imsi-208930000000001", State: s})
+	require.NoError(t, err)
+
+	var restored ueContext
+	require.NoError(t, json.Unmarshal(data, &restored))
+	assert.Equal(t, WaitingComplete, restored.State.Current())
+	assert.Equal(t, s.History(), restored.State.History())
+
+	var unsupported State
+	assert.EqualError(t, json.Unmarshal([]byte(`{"version":99,"current":"Idle"}`), &unsupported),
+		"Unsupported State snapshot version: 99")
+	assert.EqualError(t, json.Unmarshal([]byte(`{"version":1}`), &unsupported),
+		"Empty state in State snapshot")
+}
+
+func TestStateSnapshotBSON(t *testing.T) {
+	s := NewState(Completed)
+
+	data, err := bson.Marshal(ueContext{Supi: "imsi-208930000000001", State: s})
+	require.NoError(t, err)
+
+	// as stored through mongoapi
+	var doc bson.M
+	require.NoError(t, bson.Unmarshal(data, &doc))
+	assert.Equal(t, bson.M{"version": int32(StateSnapshotVersion), "current": "Completed"}, doc["state"])
+
+	var restored ueContext
+	require.NoError(t, bson.Unmarshal(data, &restored))
+	assert.Equal(t, Completed, restored.State.Current())
+	assert.Nil(t, restored.State.History())
+}
+
+func TestRestore(t *testing.T) {
+	log := newLog()
+	clock := newFakeClock()
+	entries := 0
+	f, err := NewFSM(
+		Transitions{
+			{Event: Start, From: Idle, To: WaitingComplete},
+			{Event: Expire, From: WaitingComplete, To: Failed},
+		},
+		Callbacks{
+			WaitingComplete: func(state *State, event EventType, args ArgsType) {
+				if event == EntryEvent {
+					entries++
+				}
+			},
+		},
+		nil,
+		WithStateTimers(StateTimers{
+			WaitingComplete: {Timeout: 6 * time.Second, Event: Expire},
+		}),
+		WithClock(clock),
+	)
+	require.NoError(t, err)
+
+	s := NewState(Idle)
+	require.NoError(t, f.SendEvent(s, Start, nil, log))
+	data, err := json.Marshal(s)
+	require.NoError(t, err)
+	s.StopTimers()
+
+	restored := &State{}
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.NoError(t, f.Restore(ContextWithLogger(context.Background(), log), restored))
+	assert.Equal(t, 1, entries)
+
+	clock.Advance(6 * time.Second)
+	assert.Equal(t, Failed, restored.Current())
+
+	assert.EqualError(t, f.Restore(context.Background(), NewState("Unknown")), "Unknown state: Unknown")
+}
@@ -1,6 +1,9 @@
 package fsm
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 type StateType string
 
@@ -9,8 +12,18 @@ type StateType string
 type State struct {
 	// current state of the State object
 	current StateType
-	// stateMutex ensures that all operations to current is thread-safe
+	// stateMutex ensures that all operations to current and timers are thread-safe
 	stateMutex sync.RWMutex
+	// timers stores the running state timers, see StateTimer
+	timers map[StateType]*stateTimer
+	// mailbox serializes events sent to this State, see StartMailbox
+	mailbox *mailbox
+	// history stores the latest handled events, see EnableHistory
+	history *history
+	// enteredAt is the time the current state was entered, used by Metrics
+	enteredAt time.Time
+	// trackedBy is the FSM counting this State in its Metrics, see FSM.TrackState
+	trackedBy *FSM
 }
 
 // NewState create a State object with current state set to initState
@@ -0,0 +1,138 @@
+package fsm
+
+import (
+	"context"
+	"time"
+
+	"github.com/free5gc/util/internal/clock"
+)
+
+const timerCallerInfo = "StateTimer"
+
+// Clock is the source of time used by FSM, it can be replaced by WithClock in unit tests
+type Clock = clock.Clock
+
+// Timer is a pending call created by Clock.AfterFunc
+type Timer = clock.Timer
+
+// StateTimer defines a timer started when its state is entered and stopped when the state is left,
+// Event is sent to the State if the timer expires before leaving the state (e.g. T3550, T3560)
+type StateTimer struct {
+	Timeout time.Duration
+	Event   EventType
+}
+
+type StateTimers map[StateType]StateTimer
+
+// stateTimer is a running StateTimer of a State
+type stateTimer struct {
+	timer Timer
+}
+
+// WithStateTimers registers timers on states
+func WithStateTimers(timers StateTimers) Option {
+	return func(fsm *FSM) error {
+		for state, timer := range timers {
+			fsm.timers[state] = timer
+		}
+		return nil
+	}
+}
+
+// WithClock replaces the real clock used by FSM
+func WithClock(clock Clock) Option {
+	return func(fsm *FSM) error {
+		fsm.clock = clock
+		return nil
+	}
+}
+
+// startTimer starts the timer of target if it has one,
+// the timeout event is sent with the values of ctx, but regardless of its cancellation
+func (fsm *FSM) startTimer(ctx context.Context, state *State, target StateType) {
+	timeout, ok := fsm.timers[target]
+	if !ok {
+		return
+	}
+
+	state.stateMutex.Lock()
+	defer state.stateMutex.Unlock()
+
+	if state.timers == nil {
+		state.timers = make(map[StateType]*stateTimer)
+	} else if running, ok := state.timers[target]; ok {
+		running.timer.Stop()
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	running := &stateTimer{}
+	running.timer = fsm.clock.AfterFunc(timeout.Timeout, func() {
+		fsm.expireTimer(ctx, state, target, running)
+	})
+	state.timers[target] = running
+}
+
+// stopTimer stops the timer of target if it is running
+func (fsm *FSM) stopTimer(state *State, target StateType) {
+	state.stateMutex.Lock()
+	defer state.stateMutex.Unlock()
+
+	if running, ok := state.timers[target]; ok {
+		running.timer.Stop()
+		delete(state.timers, target)
+	}
+}
+
+// expiringTimer is a timer which has expired, its timeout event is dropped
+// if it is no longer the running timer of target when the event is handled
+type expiringTimer struct {
+	target  StateType
+	running *stateTimer
+}
+
+// currentIfRunning returns the current state, timer is removed from the running timers in the same critical section.
+// ok is false if timer is not nil and has been stopped or restarted.
+func (state *State) currentIfRunning(timer *expiringTimer) (current StateType, ok bool) {
+	if timer == nil {
+		return state.Current(), true
+	}
+
+	state.stateMutex.Lock()
+	defer state.stateMutex.Unlock()
+
+	if state.timers[timer.target] != timer.running {
+		return state.current, false
+	}
+	delete(state.timers, timer.target)
+	return state.current, true
+}
+
+// expireTimer sends the timeout event of target to the State, unless the timer
+// has been stopped or restarted by the time the event is handled.
+// Without mailbox, the events of a State are not serialized: the timeout event may be handled
+// while another event leaving the state is, call StartMailbox to handle them one after the other.
+func (fsm *FSM) expireTimer(ctx context.Context, state *State, target StateType, running *stateTimer) {
+	event := fsm.timers[target].Event
+	err := fsm.dispatch(state, &envelope{
+		ctx:   ctx,
+		fsm:   fsm,
+		state: state,
+		event: event,
+		args:  ArgsType{ArgCallerInfo: timerCallerInfo},
+		timer: &expiringTimer{target: target, running: running},
+	})
+	if err != nil {
+		loggerFromContext(ctx).Warnf("Send timeout event[%s] of state[%s] failed: %+v", event, target, err)
+	}
+}
+
+// StopTimers stops all running timers of the State, e.g. before the object owning it is released
+func (state *State) StopTimers() {
+	state.stateMutex.Lock()
+	defer state.stateMutex.Unlock()
+
+	for target, running := range state.timers {
+		running.timer.Stop()
+		delete(state.timers, target)
+	}
+}
@@ -0,0 +1,138 @@
+package fsm
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/util/internal/clock"
+)
+
+// newFakeClock makes a clock whose timers fire synchronously when Advance is called
+func newFakeClock() *clock.Fake {
+	return clock.NewFake()
+}
+
+const (
+	WaitingComplete StateType = "WaitingComplete"
+	Completed       StateType = "Completed"
+	Failed          StateType = "Failed"
+)
+
+const (
+	Start    EventType = "Start"
+	Complete EventType = "Complete"
+	Expire   EventType = "Expire"
+)
+
+func newTimerFSM(t *testing.T, clock Clock) *FSM {
+	f, err := NewFSM(
+		Transitions{
+			{Event: Start, From: Idle, To: WaitingComplete},
+			{Event: Complete, From: WaitingComplete, To: Completed},
+			{Event: Expire, From: WaitingComplete, To: Failed},
+		},
+		nil,
+		nil,
+		WithStateTimers(StateTimers{
+			WaitingComplete: {Timeout: 6 * time.Second, Event: Expire},
+		}),
+		WithClock(clock),
+	)
+	require.NoError(t, err)
+	return f
+}
+
+func TestStateTimerExpire(t *testing.T) {
+	log := newLog()
+	clock := newFakeClock()
+	f := newTimerFSM(t, clock)
+	s := NewState(Idle)
+
+	require.NoError(t, f.SendEvent(s, Start, nil, log))
+	assert.Equal(t, WaitingComplete, s.Current())
+
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, WaitingComplete, s.Current())
+
+	clock.Advance(time.Second)
+	assert.Equal(t, Failed, s.Current())
+}
+
+func TestStateTimerStopOnExit(t *testing.T) {
+	log := newLog()
+	clock := newFakeClock()
+	f := newTimerFSM(t, clock)
+	s := NewState(Idle)
+
+	require.NoError(t, f.SendEvent(s, Start, nil, log))
+	require.NoError(t, f.SendEvent(s, Complete, nil, log))
+	assert.Equal(t, Completed, s.Current())
+
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, Completed, s.Current())
+	assert.Zero(t, clock.Pending())
+}
+
+func TestStateTimerStopTimers(t *testing.T) {
+	log := newLog()
+	clock := newFakeClock()
+	f := newTimerFSM(t, clock)
+	s := NewState(Idle)
+
+	require.NoError(t, f.SendEvent(s, Start, nil, log))
+	s.StopTimers()
+
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, WaitingComplete, s.Current())
+}
+
+func TestStateTimerUnknownState(t *testing.T) {
+	_, err := NewFSM(
+		Transitions{{Event: Start, From: Idle, To: WaitingComplete}},
+		nil,
+		nil,
+		WithStateTimers(StateTimers{Failed: {Timeout: time.Second, Event: Expire}}),
+	)
+	assert.EqualError(t, err, "Unknown state: Failed")
+}
+
+func TestStateTimerExpireDuringEvent(t *testing.T) {
+	log := newLog()
+	clock := newFakeClock()
+	var failed atomic.Bool
+	f, err := NewFSM(
+		Transitions{
+			{Event: Start, From: Idle, To: WaitingComplete},
+			{Event: Complete, From: WaitingComplete, To: Completed},
+			{Event: Expire, From: WaitingComplete, To: Failed},
+		},
+		Callbacks{
+			Failed: func(state *State, event EventType, args ArgsType) {
+				failed.Store(true)
+			},
+		},
+		nil,
+		WithStateTimers(StateTimers{
+			WaitingComplete: {Timeout: 6 * time.Second, Event: Expire},
+		}),
+		WithClock(clock),
+	)
+	require.NoError(t, err)
+	s := NewState(Idle)
+	s.StartMailbox(1)
+	defer s.Stop()
+	require.NoError(t, f.SendEvent(s, Start, nil, log))
+	running := s.timers[WaitingComplete]
+	require.NotNil(t, running)
+
+	// the timer expired before Complete stopped it, its event is handled after the transition
+	require.NoError(t, f.SendEvent(s, Complete, nil, log))
+	f.expireTimer(context.Background(), s, WaitingComplete, running)
+	assert.Equal(t, Completed, s.Current())
+	assert.False(t, failed.Load())
+}
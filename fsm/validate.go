@@ -0,0 +1,209 @@
+package fsm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ValidateOptions describes the expected usage of an FSM for Validate
+type ValidateOptions struct {
+	// Initial is the state of newly created States, every state should be reachable from it
+	Initial StateType
+	// Terminals are the states which are not expected to be left
+	Terminals []StateType
+	// Events are the events which may be sent to the FSM, each of them should be handled by a transition
+	Events []EventType
+}
+
+// ValidationReport lists the issues found by Validate, every list is sorted
+type ValidationReport struct {
+	// MissingCallbacks are states without callback
+	MissingCallbacks []StateType
+	// Unreachable are states which cannot be reached from the initial state
+	Unreachable []StateType
+	// UndeclaredSinks are states which cannot be left but are not declared as terminal
+	UndeclaredSinks []StateType
+	// UnhandledEvents are declared events and state timer events without any transition
+	UnhandledEvents []EventType
+}
+
+// Ok return true if no issue is found
+func (r *ValidationReport) Ok() bool {
+	return len(r.MissingCallbacks) == 0 && len(r.Unreachable) == 0 &&
+		len(r.UndeclaredSinks) == 0 && len(r.UnhandledEvents) == 0
+}
+
+func (r *ValidationReport) String() string {
+	var issues []string
+	if len(r.MissingCallbacks) > 0 {
+		issues = append(issues, fmt.Sprintf("missing callbacks: %v", r.MissingCallbacks))
+	}
+	if len(r.Unreachable) > 0 {
+		issues = append(issues, fmt.Sprintf("unreachable states: %v", r.Unreachable))
+	}
+	if len(r.UndeclaredSinks) > 0 {
+		issues = append(issues, fmt.Sprintf("sink states not terminal: %v", r.UndeclaredSinks))
+	}
+	if len(r.UnhandledEvents) > 0 {
+		issues = append(issues, fmt.Sprintf("unhandled events: %v", r.UnhandledEvents))
+	}
+	return strings.Join(issues, "; ")
+}
+
+// WithValidation makes NewFSM fail if Validate reports any issue
+func WithValidation(opts ValidateOptions) Option {
+	return func(fsm *FSM) error {
+		fsm.validateOpts = &opts
+		return nil
+	}
+}
+
+// Validate checks the transitions, callbacks and timers of FSM against opts
+func (fsm *FSM) Validate(opts ValidateOptions) *ValidationReport {
+	report := &ValidationReport{}
+	states := fsm.states()
+
+	for state := range states {
+		_, ok := fsm.callbacks[state]
+		_, okErr := fsm.errorCallbacks[state]
+		if !ok && !okErr {
+			report.MissingCallbacks = append(report.MissingCallbacks, state)
+		}
+	}
+
+	reachable := fsm.reachableFrom(opts.Initial)
+	for state := range states {
+		if !reachable[state] {
+			report.Unreachable = append(report.Unreachable, state)
+		}
+	}
+
+	terminals := make(map[StateType]bool, len(opts.Terminals))
+	for _, state := range opts.Terminals {
+		terminals[state] = true
+	}
+	for state := range fsm.targetStates(opts.Initial) {
+		if !terminals[state] && len(fsm.successors(state)) == 0 {
+			report.UndeclaredSinks = append(report.UndeclaredSinks, state)
+		}
+	}
+
+	handled := make(map[EventType]bool)
+	for key := range fsm.transitions {
+		handled[key.Event] = true
+	}
+	events := make(map[EventType]bool)
+	for _, event := range opts.Events {
+		events[event] = true
+	}
+	for _, timer := range fsm.timers {
+		events[timer.Event] = true
+	}
+	for event := range events {
+		if !handled[event] {
+			report.UnhandledEvents = append(report.UnhandledEvents, event)
+		}
+	}
+
+	sortStates(report.MissingCallbacks)
+	sortStates(report.Unreachable)
+	sortStates(report.UndeclaredSinks)
+	sort.Slice(report.UnhandledEvents, func(i, j int) bool {
+		return report.UnhandledEvents[i] < report.UnhandledEvents[j]
+	})
+	return report
+}
+
+// validate runs Validate for WithValidation
+func (fsm *FSM) validate() error {
+	if fsm.validateOpts == nil {
+		return nil
+	}
+	if report := fsm.Validate(*fsm.validateOpts); !report.Ok() {
+		return errors.Errorf("Invalid FSM: %s", report)
+	}
+	return nil
+}
+
+// states returns all states known by FSM
+func (fsm *FSM) states() map[StateType]bool {
+	states := make(map[StateType]bool)
+	for key, candidates := range fsm.transitions {
+		states[key.From] = true
+		for _, trans := range candidates {
+			states[trans.To] = true
+			if trans.Fallback != "" {
+				states[trans.Fallback] = true
+			}
+		}
+	}
+	for child, parent := range fsm.parents {
+		states[child] = true
+		states[parent] = true
+	}
+	return states
+}
+
+// targetStates returns the states a State can be set to: the initial state, transition targets and fallbacks
+func (fsm *FSM) targetStates(initial StateType) map[StateType]bool {
+	targets := map[StateType]bool{initial: true}
+	for _, candidates := range fsm.transitions {
+		for _, trans := range candidates {
+			targets[trans.To] = true
+			if trans.Fallback != "" {
+				targets[trans.Fallback] = true
+			}
+		}
+	}
+	return targets
+}
+
+// successors returns the states other than state that can be reached by one transition from state,
+// including transitions inherited from its ancestors
+func (fsm *FSM) successors(state StateType) []StateType {
+	var next []StateType
+	for _, from := range fsm.lineage(state) {
+		for key, candidates := range fsm.transitions {
+			if key.From != from {
+				continue
+			}
+			for _, trans := range candidates {
+				if trans.From != trans.To && trans.To != state {
+					next = append(next, trans.To)
+				}
+				if trans.Fallback != "" && trans.Fallback != state {
+					next = append(next, trans.Fallback)
+				}
+			}
+		}
+	}
+	return next
+}
+
+// reachableFrom returns the states that can be reached from initial, the ancestors of
+// a reachable state are reachable as well
+func (fsm *FSM) reachableFrom(initial StateType) map[StateType]bool {
+	reachable := make(map[StateType]bool)
+	visited := make(map[StateType]bool)
+	queue := []StateType{initial}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		if visited[state] {
+			continue
+		}
+		visited[state] = true
+		for _, s := range fsm.lineage(state) {
+			reachable[s] = true
+		}
+		queue = append(queue, fsm.successors(state)...)
+	}
+	return reachable
+}
+
+func sortStates(states []StateType) {
+	sort.Slice(states, func(i, j int) bool { return states[i] < states[j] })
+}
@@ -0,0 +1,74 @@
+package fsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	var trace []string
+	f := newHierarchicalFSM(t, &trace)
+
+	report := f.Validate(ValidateOptions{
+		Initial: Deregistered,
+		Events:  []EventType{Register, Deregister, Connect, Release},
+	})
+	assert.True(t, report.Ok(), report.String())
+
+	const (
+		Lost   StateType = "Lost"
+		Orphan StateType = "Orphan"
+		Ping   EventType = "Ping"
+	)
+	f, err := NewFSM(
+		Transitions{
+			{Event: Open, From: Closed, To: Opened},
+			{Event: Close, From: Opened, To: Lost, Guard: func(*State, ArgsType) bool { return false }},
+			{Event: Close, From: Opened, To: Closed},
+			{Event: Open, From: Orphan, To: Closed},
+		},
+		Callbacks{
+			Opened: func(*State, EventType, ArgsType) {},
+			Closed: func(*State, EventType, ArgsType) {},
+		},
+		nil,
+	)
+	require.NoError(t, err)
+
+	report = f.Validate(ValidateOptions{
+		Initial: Closed,
+		Events:  []EventType{Open, Close, Ping},
+	})
+	assert.False(t, report.Ok())
+	assert.Equal(t, &ValidationReport{
+		MissingCallbacks: []StateType{Lost, Orphan},
+		Unreachable:      []StateType{Orphan},
+		UndeclaredSinks:  []StateType{Lost},
+		UnhandledEvents:  []EventType{Ping},
+	}, report)
+	assert.Equal(t, "missing callbacks: [Lost Orphan]; unreachable states: [Orphan]; "+
+		"sink states not terminal: [Lost]; unhandled events: [Ping]", report.String())
+
+	report = f.Validate(ValidateOptions{Initial: Closed, Terminals: []StateType{Lost}})
+	assert.Empty(t, report.UndeclaredSinks)
+}
+
+func TestWithValidation(t *testing.T) {
+	_, err := NewFSM(
+		Transitions{
+			{Event: Start, From: Idle, To: WaitingComplete},
+			{Event: Complete, From: WaitingComplete, To: Completed},
+		},
+		Callbacks{
+			Idle:            func(*State, EventType, ArgsType) {},
+			WaitingComplete: func(*State, EventType, ArgsType) {},
+			Completed:       func(*State, EventType, ArgsType) {},
+		},
+		nil,
+		WithStateTimers(StateTimers{WaitingComplete: {Timeout: 1, Event: Expire}}),
+		WithValidation(ValidateOptions{Initial: Idle, Terminals: []StateType{Completed}}),
+	)
+	assert.EqualError(t, err, "Invalid FSM: unhandled events: [Expire]")
+}
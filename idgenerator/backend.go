@@ -0,0 +1,228 @@
+package idgenerator
+
+import (
+	"math/bits"
+
+	"github.com/free5gc/util/ippool"
+)
+
+// Backend is the data structure recording the used IDs of an IDGenerator, see NewGeneratorWithBackend
+type Backend int
+
+const (
+	// MapBackend records the used IDs in a map. Its memory grows with the number of used IDs,
+	// and Allocate scans the used IDs following the last allocated one, so it is slow on a nearly
+	// full range. It is the backend of NewGenerator.
+	MapBackend Backend = iota
+	// BitmapBackend records the used IDs in a bitmap with a summary of the full words.
+	// The bitmap is allocated by pages of 256K IDs (about 33KB) on first use and released when
+	// the page is empty; Allocate finds a free ID in near constant time even on a nearly full range.
+	// IDs are allocated in the same order as MapBackend.
+	BitmapBackend
+	// SegmentBackend records the free IDs as the segments of an ippool.LazyReusePool. Its memory
+	// grows with the number of free ranges, so it fits ranges allocated and freed in rough order,
+	// e.g. short-lived IDs; Allocate and FreeID are O(log n) of the number of free ranges.
+	// Freed IDs are reused lazily, after the never-used IDs of the current range.
+	SegmentBackend
+)
+
+// backend records the used offsets in [0, valueRange), it is protected by the lock of IDGenerator
+type backend interface {
+	// allocate marks a free offset as used, ok is false if all offsets are used
+	allocate() (offset int64, ok bool)
+	// free marks offset as free
+	free(offset int64)
+}
+
+type mapBackend struct {
+	valueRange int64
+	offset     int64
+	usedMap    map[int64]bool
+}
+
+func newMapBackend(valueRange int64) *mapBackend {
+	return &mapBackend{
+		valueRange: valueRange,
+		usedMap:    make(map[int64]bool),
+	}
+}
+
+func (b *mapBackend) allocate() (int64, bool) {
+	offsetBegin := b.offset
+	for {
+		if _, ok := b.usedMap[b.offset]; ok {
+			b.updateOffset()
+
+			if b.offset == offsetBegin {
+				return 0, false
+			}
+		} else {
+			break
+		}
+	}
+	b.usedMap[b.offset] = true
+	offset := b.offset
+	b.updateOffset()
+	return offset, true
+}
+
+func (b *mapBackend) free(offset int64) {
+	delete(b.usedMap, offset)
+}
+
+func (b *mapBackend) updateOffset() {
+	b.offset++
+	b.offset = b.offset % b.valueRange
+}
+
+const (
+	// pageBits is the log2 of the number of IDs of a bitmap page
+	pageBits  = 18
+	pageSize  = 1 << pageBits
+	pageMask  = pageSize - 1
+	pageWords = pageSize / 64
+)
+
+// bitmapPage records the used IDs of one page, the bits out of the range are set in the last page
+type bitmapPage struct {
+	words [pageWords]uint64
+	// full has a bit set for each word of all used IDs
+	full [pageWords / 64]uint64
+	// used is the number of used IDs in range
+	used int
+}
+
+type bitmapBackend struct {
+	valueRange int64
+	// offset is the offset following the last allocated one
+	offset int64
+	used   int64
+	// pages stores the pages with used IDs by their index
+	pages map[int64]*bitmapPage
+}
+
+func newBitmapBackend(valueRange int64) *bitmapBackend {
+	return &bitmapBackend{
+		valueRange: valueRange,
+		pages:      make(map[int64]*bitmapPage),
+	}
+}
+
+func (b *bitmapBackend) allocate() (int64, bool) {
+	if b.used == b.valueRange {
+		return 0, false
+	}
+
+	start := b.offset
+	for {
+		index := start >> pageBits
+		page := b.page(index)
+		if i, ok := page.next(int(start & pageMask)); ok {
+			page.set(i)
+			page.used++
+			b.used++
+			offset := index<<pageBits + int64(i)
+			b.offset = (offset + 1) % b.valueRange
+			return offset, true
+		}
+		if page.used == 0 {
+			delete(b.pages, index)
+		}
+		// b.used < b.valueRange, a free ID is found within one round
+		start = (index + 1) << pageBits
+		if start >= b.valueRange {
+			start = 0
+		}
+	}
+}
+
+func (b *bitmapBackend) free(offset int64) {
+	if offset < 0 || offset >= b.valueRange {
+		return
+	}
+	index := offset >> pageBits
+	page, ok := b.pages[index]
+	if !ok || !page.clear(int(offset&pageMask)) {
+		return
+	}
+	page.used--
+	b.used--
+	if page.used == 0 {
+		delete(b.pages, index)
+	}
+}
+
+// page returns the page of index, an empty page is made if it has no used ID
+func (b *bitmapBackend) page(index int64) *bitmapPage {
+	if page, ok := b.pages[index]; ok {
+		return page
+	}
+	page := &bitmapPage{}
+	if last := b.valueRange - index<<pageBits; last < pageSize {
+		for i := int(last); i < pageSize; i++ {
+			page.set(i)
+		}
+	}
+	b.pages[index] = page
+	return page
+}
+
+// next returns the first free bit from i in the page
+func (p *bitmapPage) next(i int) (int, bool) {
+	w := i / 64
+	if free := ^p.words[w] & (^uint64(0) << (i % 64)); free != 0 {
+		return w*64 + bits.TrailingZeros64(free), true
+	}
+	for s := (w + 1) / 64; s < len(p.full); s++ {
+		notFull := ^p.full[s]
+		if s == (w+1)/64 {
+			notFull &= ^uint64(0) << ((w + 1) % 64)
+		}
+		if notFull != 0 {
+			w = s*64 + bits.TrailingZeros64(notFull)
+			return w*64 + bits.TrailingZeros64(^p.words[w]), true
+		}
+	}
+	return 0, false
+}
+
+func (p *bitmapPage) set(i int) {
+	w := i / 64
+	p.words[w] |= 1 << (i % 64)
+	if p.words[w] == ^uint64(0) {
+		p.full[w/64] |= 1 << (w % 64)
+	}
+}
+
+// clear returns false if bit i is not set
+func (p *bitmapPage) clear(i int) bool {
+	w := i / 64
+	bit := uint64(1) << (i % 64)
+	if p.words[w]&bit == 0 {
+		return false
+	}
+	p.words[w] &^= bit
+	p.full[w/64] &^= 1 << (w % 64)
+	return true
+}
+
+type segmentBackend struct {
+	pool *ippool.LazyReusePool
+}
+
+func newSegmentBackend(valueRange int64) (*segmentBackend, error) {
+	pool, err := ippool.NewLazyReusePool(0, int(valueRange-1)) // #nosec G115
+	if err != nil {
+		return nil, err
+	}
+	return &segmentBackend{pool: pool}, nil
+}
+
+func (b *segmentBackend) allocate() (int64, bool) {
+	offset, ok := b.pool.Allocate()
+	return int64(offset), ok
+}
+
+func (b *segmentBackend) free(offset int64) {
+	b.pool.Free(int(offset))
+}
@@ -5,31 +5,117 @@ package idgenerator
 
 import (
 	"errors"
+	"fmt"
 	"sync"
+	"time"
+
+	"github.com/free5gc/util/internal/clock"
+	"github.com/free5gc/util/leakcheck"
 )
 
+// Clock provides the time of quarantines, it can be replaced in tests, see WithClock
+type Clock = clock.Clock
+
 type IDGenerator struct {
-	lock       sync.Mutex
-	minValue   int64
-	maxValue   int64
-	valueRange int64
-	offset     int64
-	usedMap    map[int64]bool
+	lock     sync.Mutex
+	minValue int64
+	maxValue int64
+	// backend records the used offsets of IDs from minValue, see Backend
+	backend backend
+	// tracker records the allocated IDs, see WithTracking
+	tracker *leakcheck.Tracker
+	// clock drives quarantines, see WithClock
+	clock Clock
+	// quarantine is the hold-down period of freed IDs, see WithQuarantine
+	quarantine time.Duration
+	// quarantined stores the quarantined offsets in the order of their release,
+	// which is also the order their quarantines end; isQuarantined indexes them
+	quarantined   []quarantinedID
+	isQuarantined map[int64]bool
+}
+
+type quarantinedID struct {
+	offset int64
+	until  time.Time
+}
+
+// Option configures an optional feature of IDGenerator, see NewGenerator
+type Option func(*IDGenerator)
+
+// WithTracking records the allocation time of each allocated ID, and its caller stack
+// if captureStack is true, so leaked IDs can be found with the audit functions of Tracker.
+func WithTracking(captureStack bool) Option {
+	return func(idGenerator *IDGenerator) {
+		idGenerator.tracker = leakcheck.NewTracker(captureStack, "github.com/free5gc/util/idgenerator.")
+	}
+}
+
+// WithQuarantine holds freed IDs for period before they can be allocated again,
+// so e.g. a just-released TEID is not handed to another session while packets are in flight.
+// Quarantined IDs are neither free nor tracked.
+func WithQuarantine(period time.Duration) Option {
+	return func(idGenerator *IDGenerator) {
+		idGenerator.quarantine = period
+	}
+}
+
+// WithClock replaces the clock of quarantines, the default is the system clock
+func WithClock(clock Clock) Option {
+	return func(idGenerator *IDGenerator) {
+		idGenerator.clock = clock
+	}
 }
 
 // Initialize an IDGenerator with minValue and maxValue.
-func NewGenerator(minValue, maxValue int64) *IDGenerator {
-	idGenerator := &IDGenerator{}
+func NewGenerator(minValue, maxValue int64, opts ...Option) *IDGenerator {
+	idGenerator := &IDGenerator{clock: clock.Real{}}
 	idGenerator.init(minValue, maxValue)
+	for _, opt := range opts {
+		opt(idGenerator)
+	}
 	return idGenerator
 }
 
+// NewGeneratorWithBackend initializes an IDGenerator with minValue and maxValue,
+// whose used IDs are recorded by backend
+func NewGeneratorWithBackend(minValue, maxValue int64, backend Backend, opts ...Option) (*IDGenerator, error) {
+	if minValue > maxValue {
+		return nil, fmt.Errorf("invalid ID range [%d, %d]", minValue, maxValue)
+	}
+	valueRange := maxValue - minValue + 1
+	if valueRange <= 0 {
+		return nil, fmt.Errorf("ID range [%d, %d] is too large", minValue, maxValue)
+	}
+
+	idGenerator := &IDGenerator{
+		minValue: minValue,
+		maxValue: maxValue,
+		clock:    clock.Real{},
+	}
+	switch backend {
+	case MapBackend:
+		idGenerator.backend = newMapBackend(valueRange)
+	case BitmapBackend:
+		idGenerator.backend = newBitmapBackend(valueRange)
+	case SegmentBackend:
+		b, err := newSegmentBackend(valueRange)
+		if err != nil {
+			return nil, err
+		}
+		idGenerator.backend = b
+	default:
+		return nil, fmt.Errorf("unknown backend: %d", backend)
+	}
+	for _, opt := range opts {
+		opt(idGenerator)
+	}
+	return idGenerator, nil
+}
+
 func (idGenerator *IDGenerator) init(minValue, maxValue int64) {
 	idGenerator.minValue = minValue
 	idGenerator.maxValue = maxValue
-	idGenerator.valueRange = maxValue - minValue + 1
-	idGenerator.offset = 0
-	idGenerator.usedMap = make(map[int64]bool)
+	idGenerator.backend = newMapBackend(maxValue - minValue + 1)
 }
 
 // Allocate and return an id in range [minValue, maxValue]
@@ -37,36 +123,84 @@ func (idGenerator *IDGenerator) Allocate() (int64, error) {
 	idGenerator.lock.Lock()
 	defer idGenerator.lock.Unlock()
 
-	offsetBegin := idGenerator.offset
-	for {
-		if _, ok := idGenerator.usedMap[idGenerator.offset]; ok {
-			idGenerator.updateOffset()
-
-			if idGenerator.offset == offsetBegin {
-				return 0, errors.New("no available value range to allocate id")
-			}
-		} else {
-			break
-		}
+	idGenerator.releaseQuarantined()
+	offset, ok := idGenerator.backend.allocate()
+	if !ok {
+		return 0, errors.New("no available value range to allocate id")
+	}
+	id := offset + idGenerator.minValue
+	if idGenerator.tracker != nil {
+		idGenerator.tracker.Track(id)
 	}
-	idGenerator.usedMap[idGenerator.offset] = true
-	id := idGenerator.offset + idGenerator.minValue
-	idGenerator.updateOffset()
 	return id, nil
 }
 
 // param:
 //   - id: id to free
+//
+// If the generator has a quarantine (see WithQuarantine), id is not allocated again until the quarantine ends.
 func (idGenerator *IDGenerator) FreeID(id int64) {
 	if id < idGenerator.minValue || id > idGenerator.maxValue {
 		return
 	}
 	idGenerator.lock.Lock()
 	defer idGenerator.lock.Unlock()
-	delete(idGenerator.usedMap, id-idGenerator.minValue)
+	offset := id - idGenerator.minValue
+	if idGenerator.quarantine > 0 {
+		idGenerator.quarantineOffset(offset)
+	} else {
+		idGenerator.backend.free(offset)
+	}
+	if idGenerator.tracker != nil {
+		idGenerator.tracker.Untrack(id)
+	}
+}
+
+// quarantineOffset holds offset until the quarantine ends, a quarantined offset is not freed twice
+func (idGenerator *IDGenerator) quarantineOffset(offset int64) {
+	if idGenerator.isQuarantined[offset] {
+		return
+	}
+	if idGenerator.isQuarantined == nil {
+		idGenerator.isQuarantined = make(map[int64]bool)
+	}
+	idGenerator.isQuarantined[offset] = true
+	idGenerator.quarantined = append(idGenerator.quarantined, quarantinedID{
+		offset: offset,
+		until:  idGenerator.clock.Now().Add(idGenerator.quarantine),
+	})
+}
+
+// releaseQuarantined frees the offsets whose quarantine ended
+func (idGenerator *IDGenerator) releaseQuarantined() {
+	now := idGenerator.clock.Now()
+	i := 0
+	for ; i < len(idGenerator.quarantined) && !idGenerator.quarantined[i].until.After(now); i++ {
+		offset := idGenerator.quarantined[i].offset
+		delete(idGenerator.isQuarantined, offset)
+		idGenerator.backend.free(offset)
+	}
+	idGenerator.quarantined = idGenerator.quarantined[i:]
+}
+
+// Quarantined returns the number of IDs in quarantine
+func (idGenerator *IDGenerator) Quarantined() int {
+	idGenerator.lock.Lock()
+	defer idGenerator.lock.Unlock()
+	idGenerator.releaseQuarantined()
+	return len(idGenerator.quarantined)
 }
 
-func (idGenerator *IDGenerator) updateOffset() {
-	idGenerator.offset++
-	idGenerator.offset = idGenerator.offset % idGenerator.valueRange
+// Tracker returns the tracker of allocated IDs, nil if the generator is made without WithTracking
+func (idGenerator *IDGenerator) Tracker() *leakcheck.Tracker {
+	return idGenerator.tracker
+}
+
+// SetOwner tags the allocation of id with owner, e.g. the SUPI of the UE,
+// it returns false if the generator is not tracked or id is not allocated
+func (idGenerator *IDGenerator) SetOwner(id int64, owner string) bool {
+	if idGenerator.tracker == nil {
+		return false
+	}
+	return idGenerator.tracker.SetOwner(id, owner)
 }
@@ -2,10 +2,13 @@ package idgenerator
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/free5gc/util/internal/clock"
 )
 
 func TestAllocate(t *testing.T) {
@@ -152,3 +155,301 @@ func TestTriggerNoSpaceToAllocateError(t *testing.T) {
 		})
 	}
 }
+
+func TestTracking(t *testing.T) {
+	idGenerator := NewGenerator(1, 10, WithTracking(false))
+	tracker := idGenerator.Tracker()
+
+	id1, err := idGenerator.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := idGenerator.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !idGenerator.SetOwner(id1, "ue-1") || !idGenerator.SetOwner(id2, "ue-2") {
+		t.Fatal("SetOwner failed")
+	}
+	idGenerator.FreeID(id1)
+	if idGenerator.SetOwner(id1, "ue-1") {
+		t.Error("SetOwner of a freed id")
+	}
+
+	orphans := tracker.Orphans(func(owner string) bool { return false })
+	if len(orphans) != 1 || orphans[0].Value != id2 || orphans[0].Owner != "ue-2" {
+		t.Errorf("unexpected orphans: %+v", orphans)
+	}
+
+	// the option applies to every backend
+	idGenerator, err = NewGeneratorWithBackend(1, 10, SegmentBackend, WithTracking(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id1, err = idGenerator.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !idGenerator.SetOwner(id1, "ue-1") {
+		t.Error("SetOwner failed")
+	}
+	if NewGenerator(1, 10).SetOwner(1, "ue-1") {
+		t.Error("SetOwner without tracking")
+	}
+}
+
+func TestTypedGenerator(t *testing.T) {
+	type teid uint32
+
+	g, err := New[teid](math.MaxUint32-2, math.MaxUint32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []teid{math.MaxUint32 - 2, math.MaxUint32 - 1, math.MaxUint32} {
+		id, err := g.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != expected {
+			t.Errorf("expected id: %d, output id: %d", expected, id)
+		}
+	}
+	if _, err = g.Allocate(); err == nil {
+		t.Error("expect return error, but error is nil")
+	}
+	g.FreeID(math.MaxUint32 - 1)
+	g.FreeID(1)
+	if id, err := g.Allocate(); err != nil || id != math.MaxUint32-1 {
+		t.Errorf("expected id: %d, output id: %d, error: %v", uint32(math.MaxUint32-1), id, err)
+	}
+
+	negative, err := New[int64](-2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := negative.Allocate(); err != nil || id != -2 {
+		t.Errorf("expected id: -2, output id: %d, error: %v", id, err)
+	}
+}
+
+func TestTypedGeneratorRange(t *testing.T) {
+	if _, err := New[uint32](10, 9); err == nil {
+		t.Error("expect error of an empty range")
+	}
+	if _, err := New[uint64](0, math.MaxUint64); err == nil {
+		t.Error("expect error of a too large range")
+	}
+	if _, err := New[int64](math.MinInt64, 0); err == nil {
+		t.Error("expect error of a too large range")
+	}
+	if _, err := New[uint64](0, math.MaxInt64-1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPresetGenerators(t *testing.T) {
+	amf := NewAmfUeNgapIDGenerator()
+	if amf.Min() != 0 || amf.Max() != 1<<40-1 {
+		t.Errorf("unexpected AMF UE NGAP ID range [%d, %d]", amf.Min(), amf.Max())
+	}
+	ran := NewRanUeNgapIDGenerator()
+	if ran.Min() != 0 || ran.Max() != math.MaxUint32 {
+		t.Errorf("unexpected RAN UE NGAP ID range [%d, %d]", ran.Min(), ran.Max())
+	}
+	seid := NewSEIDGenerator()
+	if seid.Min() != 1 || seid.Max() != math.MaxInt64 {
+		t.Errorf("unexpected SEID range [%d, %d]", seid.Min(), seid.Max())
+	}
+
+	teid := NewTEIDGenerator()
+	if teid.Min() != 1 || teid.Max() != math.MaxUint32 {
+		t.Errorf("unexpected TEID range [%d, %d]", teid.Min(), teid.Max())
+	}
+	id, err := teid.Allocate()
+	if err != nil || id != 1 {
+		t.Errorf("expected TEID: 1, output TEID: %d, error: %v", id, err)
+	}
+	teid.FreeID(0)
+}
+
+func TestBackends(t *testing.T) {
+	backendNames := map[Backend]string{MapBackend: "map", BitmapBackend: "bitmap", SegmentBackend: "segment"}
+	testCases := []struct {
+		minValue int64
+		maxValue int64
+		backends []Backend
+	}{
+		{1, 10, []Backend{MapBackend, BitmapBackend, SegmentBackend}},
+		{-5, 1000, []Backend{MapBackend, BitmapBackend, SegmentBackend}},
+		// the map backend is too slow to fill a large range
+		{1, 3*pageSize + 5, []Backend{BitmapBackend, SegmentBackend}},
+	}
+
+	for _, testCase := range testCases {
+		for _, backend := range testCase.backends {
+			name := backendNames[backend]
+			t.Run(fmt.Sprintf("%s, minValue: %d, maxValue: %d", name, testCase.minValue, testCase.maxValue), func(t *testing.T) {
+				idGenerator, err := NewGeneratorWithBackend(testCase.minValue, testCase.maxValue, backend)
+				if err != nil {
+					t.Fatal(err)
+				}
+				valueRange := int(testCase.maxValue - testCase.minValue + 1)
+				r := rand.New(rand.NewSource(1))
+				used := make(map[int64]bool)
+				var ids []int64
+
+				for i := 0; i < 3*valueRange; i++ {
+					if len(ids) == valueRange || len(ids) > 0 && r.Intn(3) == 0 {
+						k := r.Intn(len(ids))
+						idGenerator.FreeID(ids[k])
+						delete(used, ids[k])
+						ids[k] = ids[len(ids)-1]
+						ids = ids[:len(ids)-1]
+						continue
+					}
+					id, err := idGenerator.Allocate()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if id < testCase.minValue || id > testCase.maxValue || used[id] {
+						t.Fatalf("ID %d is out of range or allocated", id)
+					}
+					used[id] = true
+					ids = append(ids, id)
+				}
+
+				for len(ids) < valueRange {
+					id, err := idGenerator.Allocate()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if used[id] {
+						t.Fatalf("ID %d has been allocated", id)
+					}
+					used[id] = true
+					ids = append(ids, id)
+				}
+				if _, err = idGenerator.Allocate(); err == nil {
+					t.Error("expect return error, but error is nil")
+				}
+			})
+		}
+	}
+}
+
+func TestBitmapBackendOrder(t *testing.T) {
+	// the bitmap backend allocates IDs in the same order as the map backend
+	mapGenerator := NewGenerator(0, pageSize+100)
+	bitmapGenerator, err := NewGeneratorWithBackend(0, pageSize+100, BitmapBackend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	var ids []int64
+	// the offset wraps around the range, which is about half occupied at the end
+	for i := 0; i < 3*pageSize/2; i++ {
+		if len(ids) > 0 && r.Intn(3) == 0 {
+			k := r.Intn(len(ids))
+			mapGenerator.FreeID(ids[k])
+			bitmapGenerator.FreeID(ids[k])
+			ids[k] = ids[len(ids)-1]
+			ids = ids[:len(ids)-1]
+			continue
+		}
+		expected, err1 := mapGenerator.Allocate()
+		id, err2 := bitmapGenerator.Allocate()
+		if id != expected || (err1 == nil) != (err2 == nil) {
+			t.Fatalf("expected id: %d (%v), output id: %d (%v)", expected, err1, id, err2)
+		}
+		if err1 == nil {
+			ids = append(ids, id)
+		}
+	}
+}
+
+func TestNewGeneratorWithBackend(t *testing.T) {
+	if _, err := NewGeneratorWithBackend(10, 9, BitmapBackend); err == nil {
+		t.Error("expect error of an empty range")
+	}
+	if _, err := NewGeneratorWithBackend(math.MinInt64, math.MaxInt64, BitmapBackend); err == nil {
+		t.Error("expect error of a too large range")
+	}
+	if _, err := NewGeneratorWithBackend(1, 10, Backend(-1)); err == nil {
+		t.Error("expect error of an unknown backend")
+	}
+}
+
+// benchmarkOccupied measures Allocate and FreeID of a range of 2^22 IDs kept 99% occupied
+func benchmarkOccupied(b *testing.B, backend Backend) {
+	const valueRange = 1 << 22
+	idGenerator, err := NewGeneratorWithBackend(0, valueRange-1, backend)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ids := make([]int64, 0, valueRange)
+	for i := 0; i < valueRange; i++ {
+		id, err := idGenerator.Allocate()
+		if err != nil {
+			b.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	r := rand.New(rand.NewSource(1))
+	r.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	for _, id := range ids[valueRange*99/100:] {
+		idGenerator.FreeID(id)
+	}
+	ids = ids[:valueRange*99/100]
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := r.Intn(len(ids))
+		idGenerator.FreeID(ids[k])
+		id, err := idGenerator.Allocate()
+		if err != nil {
+			b.Fatal(err)
+		}
+		ids[k] = id
+	}
+}
+
+func BenchmarkAllocate_Map(b *testing.B) {
+	benchmarkOccupied(b, MapBackend)
+}
+
+func BenchmarkAllocate_Bitmap(b *testing.B) {
+	benchmarkOccupied(b, BitmapBackend)
+}
+
+func BenchmarkAllocate_Segment(b *testing.B) {
+	benchmarkOccupied(b, SegmentBackend)
+}
+
+func TestQuarantine(t *testing.T) {
+	fake := clock.NewFake()
+	g, err := New[uint32](1, 3, WithQuarantine(time.Minute), WithClock(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err = g.Allocate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g.FreeID(2)
+	g.FreeID(2)
+	if g.Quarantined() != 1 {
+		t.Errorf("expected 1 quarantined id, got %d", g.Quarantined())
+	}
+	if _, err = g.Allocate(); err == nil {
+		t.Error("a quarantined id is allocated")
+	}
+
+	fake.Advance(time.Minute)
+	if id, err := g.Allocate(); err != nil || id != 2 {
+		t.Errorf("expected id: 2, output id: %d, error: %v", id, err)
+	}
+	if g.Quarantined() != 0 {
+		t.Errorf("expected no quarantined id, got %d", g.Quarantined())
+	}
+}
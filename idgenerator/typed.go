@@ -0,0 +1,121 @@
+package idgenerator
+
+import (
+	"fmt"
+	"math"
+)
+
+// ID is the type of IDs allocated by a Generator
+type ID interface {
+	~uint32 | ~uint64 | ~int64
+}
+
+// 3GPP ranges of the IDs allocated by the preset generators
+const (
+	// MaxAmfUeNgapID is the maximum AMF UE NGAP ID, TS 38.413 9.3.3.1
+	MaxAmfUeNgapID int64 = 1<<40 - 1
+	// MaxRanUeNgapID is the maximum RAN UE NGAP ID, TS 38.413 9.3.3.2
+	MaxRanUeNgapID int64 = 1<<32 - 1
+	// MaxSEID is the maximum SEID allocated by NewSEIDGenerator, the span of a Generator is below 2^63
+	MaxSEID uint64 = math.MaxInt64
+)
+
+// Generator is an IDGenerator of typed IDs, e.g. uint32 TEIDs, so callers do not convert from int64.
+// It allocates IDs in range [minValue, maxValue], in the same order as IDGenerator.
+type Generator[T ID] struct {
+	minValue T
+	maxValue T
+	// ids allocates the offsets of IDs from minValue
+	ids *IDGenerator
+}
+
+// New makes a Generator of IDs in range [minValue, maxValue], whose used IDs are recorded by MapBackend.
+// The range must not be empty, and must have less than 2^63 IDs. opts configure the generator,
+// e.g. WithQuarantine.
+func New[T ID](minValue, maxValue T, opts ...Option) (*Generator[T], error) {
+	return NewWithBackend(minValue, maxValue, MapBackend, opts...)
+}
+
+// NewWithBackend is New with the used IDs recorded by backend
+func NewWithBackend[T ID](minValue, maxValue T, backend Backend, opts ...Option) (*Generator[T], error) {
+	if minValue > maxValue {
+		return nil, fmt.Errorf("invalid ID range [%d, %d]", minValue, maxValue)
+	}
+	// the difference is exact for int64 too, in two's complement
+	span := uint64(maxValue) - uint64(minValue)
+	if span >= math.MaxInt64 {
+		return nil, fmt.Errorf("ID range [%d, %d] is too large", minValue, maxValue)
+	}
+	ids, err := NewGeneratorWithBackend(0, int64(span), backend, opts...) // #nosec G115
+	if err != nil {
+		return nil, err
+	}
+	return &Generator[T]{
+		minValue: minValue,
+		maxValue: maxValue,
+		ids:      ids,
+	}, nil
+}
+
+// NewAmfUeNgapIDGenerator makes a Generator of AMF UE NGAP IDs, in range [0, 2^40-1]
+func NewAmfUeNgapIDGenerator() *Generator[int64] {
+	return mustNew(0, MaxAmfUeNgapID)
+}
+
+// NewRanUeNgapIDGenerator makes a Generator of RAN UE NGAP IDs, in range [0, 2^32-1]
+func NewRanUeNgapIDGenerator() *Generator[int64] {
+	return mustNew(0, MaxRanUeNgapID)
+}
+
+// NewTEIDGenerator makes a Generator of GTP-U TEIDs, in range [1, 2^32-1].
+// TEID 0 is not allocated, it is used in the GTP-U header of Echo and Error Indication messages.
+func NewTEIDGenerator() *Generator[uint32] {
+	return mustNew[uint32](1, math.MaxUint32)
+}
+
+// NewSEIDGenerator makes a Generator of PFCP SEIDs, in range [1, MaxSEID].
+// SEID 0 is not allocated, it is sent before the peer SEID is known, e.g. in Session Establishment Request.
+func NewSEIDGenerator() *Generator[uint64] {
+	return mustNew[uint64](1, MaxSEID)
+}
+
+// mustNew makes a preset Generator, with BitmapBackend for the large 3GPP ranges
+func mustNew[T ID](minValue, maxValue T) *Generator[T] {
+	g, err := NewWithBackend(minValue, maxValue, BitmapBackend)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// Min returns the minimum ID of the generator
+func (g *Generator[T]) Min() T {
+	return g.minValue
+}
+
+// Max returns the maximum ID of the generator
+func (g *Generator[T]) Max() T {
+	return g.maxValue
+}
+
+// Allocate and return an id in range [minValue, maxValue]
+func (g *Generator[T]) Allocate() (T, error) {
+	offset, err := g.ids.Allocate()
+	if err != nil {
+		return 0, err
+	}
+	return g.minValue + T(offset), nil // #nosec G115
+}
+
+// FreeID frees id, ids out of range are ignored
+func (g *Generator[T]) FreeID(id T) {
+	if id < g.minValue || id > g.maxValue {
+		return
+	}
+	g.ids.FreeID(int64(uint64(id) - uint64(g.minValue))) // #nosec G115
+}
+
+// Quarantined returns the number of IDs in quarantine, see WithQuarantine
+func (g *Generator[T]) Quarantined() int {
+	return g.ids.Quarantined()
+}
@@ -0,0 +1,29 @@
+// Package clock is the source of time shared by the timers of fsm and ippool,
+// so it can be replaced by Fake in unit tests.
+package clock
+
+import "time"
+
+// Clock provides the current time and timers
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d has elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call created by Clock.AfterFunc
+type Timer interface {
+	// Stop prevents the Timer from firing, it returns false if the Timer has already fired or been stopped
+	Stop() bool
+}
+
+// Real is the system clock
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+func (Real) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
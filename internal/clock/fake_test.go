@@ -0,0 +1,27 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	c := NewFake()
+	start := c.Now()
+
+	var fired []int
+	c.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	c.AfterFunc(time.Second, func() { fired = append(fired, 1) })
+	stopped := c.AfterFunc(time.Second, func() { fired = append(fired, 0) })
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+	assert.Equal(t, 2, c.Pending())
+
+	// the expired timers fire in the order of their deadlines
+	c.Advance(3 * time.Second)
+	assert.Equal(t, []int{1, 2}, fired)
+	assert.Equal(t, start.Add(3*time.Second), c.Now())
+	assert.Zero(t, c.Pending())
+}
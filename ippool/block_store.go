@@ -0,0 +1,111 @@
+package ippool
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// BlockStore is the store shared by the replicas of a DistributedPool, which records the owner of each
+// block of the pool. Each operation must be atomic across replicas.
+type BlockStore interface {
+	// Init adds the blocks which are not in the store yet, as free blocks
+	Init(blocks []Range) error
+	// Claim assigns the first block which is free or whose lease expired at now to owner until expires,
+	// including the expired blocks of owner itself. ok is false if all blocks are leased.
+	Claim(owner string, now, expires time.Time) (block Range, ok bool, err error)
+	// Owned returns the blocks owned by owner, including the ones whose lease expired but which are
+	// not claimed by another owner yet
+	Owned(owner string) ([]Range, error)
+	// Renew extends the lease of block until expires, ok is false if block is not owned by owner anymore
+	Renew(block Range, owner string, expires time.Time) (ok bool, err error)
+	// Return makes block of owner free
+	Return(block Range, owner string) error
+}
+
+type blockLease struct {
+	block   Range
+	owner   string
+	expires time.Time
+}
+
+// MemoryBlockStore is a BlockStore in memory, for replicas in the same process and for tests
+type MemoryBlockStore struct {
+	mtx    sync.Mutex
+	blocks map[int]*blockLease
+}
+
+// NewMemoryBlockStore makes an empty MemoryBlockStore
+func NewMemoryBlockStore() *MemoryBlockStore {
+	return &MemoryBlockStore{blocks: make(map[int]*blockLease)}
+}
+
+func (s *MemoryBlockStore) Init(blocks []Range) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, block := range blocks {
+		if _, ok := s.blocks[block.First]; !ok {
+			s.blocks[block.First] = &blockLease{block: block}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryBlockStore) Claim(owner string, now, expires time.Time) (Range, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var claimed *blockLease
+	for _, lease := range s.blocks {
+		if lease.owner != "" && !lease.expires.Before(now) {
+			continue
+		}
+		if claimed == nil || lease.block.First < claimed.block.First {
+			claimed = lease
+		}
+	}
+	if claimed == nil {
+		return Range{}, false, nil
+	}
+	claimed.owner = owner
+	claimed.expires = expires
+	return claimed.block, true, nil
+}
+
+func (s *MemoryBlockStore) Owned(owner string) ([]Range, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var owned []Range
+	for _, lease := range s.blocks {
+		if lease.owner == owner {
+			owned = append(owned, lease.block)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i].First < owned[j].First })
+	return owned, nil
+}
+
+func (s *MemoryBlockStore) Renew(block Range, owner string, expires time.Time) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	lease, ok := s.blocks[block.First]
+	if !ok || lease.owner != owner {
+		return false, nil
+	}
+	lease.expires = expires
+	return true, nil
+}
+
+func (s *MemoryBlockStore) Return(block Range, owner string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if lease, ok := s.blocks[block.First]; ok && lease.owner == owner {
+		lease.owner = ""
+		lease.expires = time.Time{}
+	}
+	return nil
+}
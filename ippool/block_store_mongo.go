@@ -0,0 +1,123 @@
+package ippool
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/free5gc/util/mongoapi"
+)
+
+// MongoBlockStore is a BlockStore keeping one document per block in a MongoDB collection via mongoapi,
+// blocks are claimed atomically by findAndModify. Several pools can share a collection with different
+// pool names, a unique index on {pool, first} is recommended. mongoapi.SetMongoDB must be called
+// before using it.
+type MongoBlockStore struct {
+	collName string
+	pool     string
+}
+
+// NewMongoBlockStore makes a MongoBlockStore of the pool named pool in collection collName
+func NewMongoBlockStore(collName, pool string) *MongoBlockStore {
+	return &MongoBlockStore{collName: collName, pool: pool}
+}
+
+func (s *MongoBlockStore) blockFilter(block Range, owner string) bson.M {
+	return bson.M{"pool": s.pool, "first": block.First, "owner": owner}
+}
+
+func (s *MongoBlockStore) Init(blocks []Range) error {
+	for _, block := range blocks {
+		filter := bson.M{"pool": s.pool, "first": block.First}
+		update := bson.M{"$setOnInsert": bson.M{"last": block.Last, "owner": "", "expires": int64(0)}}
+		if _, err := mongoapi.RestfulAPIFindOneAndUpdate(s.collName, filter, update, nil, true); err != nil {
+			return errors.Wrapf(err, "MongoBlockStore init")
+		}
+	}
+	return nil
+}
+
+func (s *MongoBlockStore) Claim(owner string, now, expires time.Time) (Range, bool, error) {
+	filter := bson.M{
+		"pool": s.pool,
+		"$or": bson.A{
+			bson.M{"owner": ""},
+			bson.M{"expires": bson.M{"$lt": now.UnixNano()}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires": expires.UnixNano()}}
+	byFirst := bson.D{{Key: "first", Value: 1}}
+	doc, err := mongoapi.RestfulAPIFindOneAndUpdate(s.collName, filter, update, byFirst, false)
+	if err != nil {
+		return Range{}, false, errors.Wrapf(err, "MongoBlockStore claim")
+	}
+	if doc == nil {
+		return Range{}, false, nil
+	}
+	block, err := blockOf(doc)
+	if err != nil {
+		return Range{}, false, err
+	}
+	return block, true, nil
+}
+
+func (s *MongoBlockStore) Owned(owner string) ([]Range, error) {
+	docs, err := mongoapi.RestfulAPIGetMany(s.collName, bson.M{"pool": s.pool, "owner": owner})
+	if err != nil {
+		return nil, errors.Wrapf(err, "MongoBlockStore owned")
+	}
+
+	owned := make([]Range, 0, len(docs))
+	for _, doc := range docs {
+		block, err := blockOf(doc)
+		if err != nil {
+			return nil, err
+		}
+		owned = append(owned, block)
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i].First < owned[j].First })
+	return owned, nil
+}
+
+func (s *MongoBlockStore) Renew(block Range, owner string, expires time.Time) (bool, error) {
+	update := bson.M{"$set": bson.M{"expires": expires.UnixNano()}}
+	doc, err := mongoapi.RestfulAPIFindOneAndUpdate(s.collName, s.blockFilter(block, owner), update, nil, false)
+	if err != nil {
+		return false, errors.Wrapf(err, "MongoBlockStore renew")
+	}
+	return doc != nil, nil
+}
+
+func (s *MongoBlockStore) Return(block Range, owner string) error {
+	update := bson.M{"$set": bson.M{"owner": "", "expires": int64(0)}}
+	_, err := mongoapi.RestfulAPIFindOneAndUpdate(s.collName, s.blockFilter(block, owner), update, nil, false)
+	if err != nil {
+		return errors.Wrapf(err, "MongoBlockStore return")
+	}
+	return nil
+}
+
+// blockOf decodes the range of a block document
+func blockOf(doc map[string]interface{}) (Range, error) {
+	first, okFirst := intOf(doc["first"])
+	last, okLast := intOf(doc["last"])
+	if !okFirst || !okLast {
+		return Range{}, errors.Errorf("MongoBlockStore: invalid block %v", doc)
+	}
+	return Range{First: first, Last: last}, nil
+}
+
+func intOf(value interface{}) (int, bool) {
+	switch value := value.(type) {
+	case int32:
+		return int(value), true
+	case int64:
+		return int(value), true
+	case int:
+		return value, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,366 @@
+package ippool
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxBlocks limits the number of blocks of a DistributedPool
+const maxBlocks = 1 << 16
+
+var errClosed = errors.New("DistributedPool is closed")
+
+// DistributedPool is an IPPool shared by several replicas, e.g. the SMF instances serving one DNN.
+//
+// The range of the pool is divided into blocks of blockSize values. Each replica claims blocks from
+// a shared BlockStore and allocates only from its own blocks, so no address is handed out twice.
+// Blocks are leased for leaseTimeout and renewed in the background, the blocks of a crashed replica
+// can be claimed by the others once their lease expires. Close returns the blocks on shutdown.
+type DistributedPool struct {
+	mtx   sync.Mutex
+	local *IPPool
+	// usable are the values not reserved by NewIPPool, e.g. the network id and broadcast address
+	usable       []Range
+	store        BlockStore
+	owner        string
+	blockSize    int
+	leaseTimeout time.Duration
+	// blocks stores the claimed blocks by their first value
+	blocks     map[int]Range
+	renewTimer Timer
+	closed     bool
+	blockLost  func(block Range)
+}
+
+// NewDistributedPool makes the DistributedPool of cidr for the replica named owner, which must be unique
+// and stable across restarts: the blocks still leased to owner in store are taken back, so the addresses
+// of recovered sessions can be reallocated by Reallocate. opts are applied to the local IPPool.
+func NewDistributedPool(
+	cidr string,
+	store BlockStore,
+	owner string,
+	blockSize int,
+	leaseTimeout time.Duration,
+	opts ...PoolOption,
+) (*DistributedPool, error) {
+	if owner == "" || blockSize <= 0 || leaseTimeout <= 0 {
+		return nil, errors.Errorf("NewDistributedPool: invalid owner %q, block size %d or lease timeout %s",
+			owner, blockSize, leaseTimeout)
+	}
+
+	template, err := NewIPPool(cidr)
+	if err != nil {
+		return nil, err
+	}
+	local, err := NewIPPool(cidr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// nothing is allocatable until blocks are claimed
+	if err = local.Pool.Reserve(local.Pool.Min(), local.Pool.Max()); err != nil {
+		return nil, errors.Wrapf(err, "NewDistributedPool")
+	}
+
+	poolMin, poolMax := local.Pool.Min(), local.Pool.Max()
+	if (poolMax-poolMin)/blockSize >= maxBlocks {
+		return nil, errors.Errorf("NewDistributedPool: block size %d is too small for %s", blockSize, cidr)
+	}
+	var blocks []Range
+	for first := poolMin; ; first += blockSize {
+		last := poolMax
+		if poolMax-first >= blockSize {
+			last = first + blockSize - 1
+		}
+		blocks = append(blocks, Range{First: first, Last: last})
+		if last == poolMax {
+			break
+		}
+	}
+	if err = store.Init(blocks); err != nil {
+		return nil, errors.Wrapf(err, "NewDistributedPool")
+	}
+
+	d := &DistributedPool{
+		local:        local,
+		usable:       template.Pool.freeRanges(),
+		store:        store,
+		owner:        owner,
+		blockSize:    blockSize,
+		leaseTimeout: leaseTimeout,
+		blocks:       make(map[int]Range),
+	}
+
+	// the blocks whose lease expired during a restart are kept unless they are claimed by
+	// another replica, which is found by the renewal
+	owned, err := store.Owned(owner)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewDistributedPool")
+	}
+	for _, block := range owned {
+		if err = d.addBlock(block); err != nil {
+			return nil, errors.Wrapf(err, "NewDistributedPool")
+		}
+	}
+	if err = d.Renew(); err != nil {
+		return nil, err
+	}
+	d.scheduleRenew()
+	return d, nil
+}
+
+func (d *DistributedPool) now() time.Time {
+	return d.local.Pool.clock.Now()
+}
+
+// Local returns the local IPPool, e.g. to be added to Metrics. Addresses must be allocated and
+// released through the DistributedPool.
+func (d *DistributedPool) Local() *IPPool {
+	return d.local
+}
+
+// Blocks returns the blocks claimed by the replica in ascending order
+func (d *DistributedPool) Blocks() []Range {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	blocks := make([]Range, 0, len(d.blocks))
+	for _, block := range d.blocks {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].First < blocks[j].First })
+	return blocks
+}
+
+// OnBlockLost sets the function called with each block found owned by another replica on renewal,
+// e.g. after a long pause of the process. The addresses of the block are not allocated anymore.
+func (d *DistributedPool) OnBlockLost(f func(block Range)) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.blockLost = f
+}
+
+// Allocate allocates request, which must be in a block of the replica, or an address of its blocks
+// if request is nil. A new block is claimed when the blocks of the replica are exhausted.
+func (d *DistributedPool) Allocate(request net.IP) (net.IP, error) {
+	for {
+		ip, exhausted, err := d.allocateLocal(request)
+		if !exhausted {
+			return ip, err
+		}
+		// the store is accessed without the lock, the new block may be exhausted by then
+		if err = d.claim(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// allocateLocal allocates from the blocks of the replica, exhausted is true if a block must be claimed
+func (d *DistributedPool) allocateLocal(request net.IP) (ip net.IP, exhausted bool, err error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.closed {
+		return nil, false, errClosed
+	}
+	if request != nil {
+		if err = d.checkOwned(request); err != nil {
+			return nil, false, err
+		}
+		ip, err = d.local.Allocate(request)
+		return ip, false, err
+	}
+
+	if d.local.Pool.Remain() == 0 {
+		return nil, true, nil
+	}
+	ip, err = d.local.Allocate(nil)
+	return ip, false, err
+}
+
+// Reallocate marks request as used, see IPPool.Reallocate.
+// inUsed is also true if request is not in a block of the replica.
+func (d *DistributedPool) Reallocate(request net.IP) (net.IP, bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if request == nil {
+		return nil, false
+	}
+	if d.checkOwned(request) != nil {
+		return request, true
+	}
+	return d.local.Reallocate(request)
+}
+
+// Release returns ip to the block of the replica containing it
+func (d *DistributedPool) Release(ip net.IP) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if err := d.checkOwned(ip); err != nil {
+		return errors.Wrapf(err, "failed to release UE Address")
+	}
+	return d.local.Release(ip)
+}
+
+// Renew extends the leases of the blocks of the replica, it is called periodically in the background.
+// The blocks lost to other replicas are dropped.
+func (d *DistributedPool) Renew() error {
+	d.mtx.Lock()
+	expires := d.now().Add(d.leaseTimeout)
+	blocks := make([]Range, 0, len(d.blocks))
+	for _, block := range d.blocks {
+		blocks = append(blocks, block)
+	}
+	d.mtx.Unlock()
+
+	// the store is accessed without the lock, so allocations do not wait for it
+	var lost []Range
+	var renewErr error
+	for _, block := range blocks {
+		ok, err := d.store.Renew(block, d.owner, expires)
+		if err != nil {
+			if renewErr == nil {
+				renewErr = errors.Wrapf(err, "DistributedPool renew")
+			}
+			continue
+		}
+		if !ok {
+			lost = append(lost, block)
+		}
+	}
+
+	d.mtx.Lock()
+	removed := lost[:0]
+	for _, block := range lost {
+		if _, owned := d.blocks[block.First]; !owned {
+			// returned by Close in the meantime
+			continue
+		}
+		if err := d.removeBlock(block); err != nil && renewErr == nil {
+			renewErr = errors.Wrapf(err, "DistributedPool renew")
+		}
+		removed = append(removed, block)
+	}
+	blockLost := d.blockLost
+	d.mtx.Unlock()
+
+	if blockLost != nil {
+		for _, block := range removed {
+			blockLost(block)
+		}
+	}
+	return renewErr
+}
+
+func (d *DistributedPool) scheduleRenew() {
+	d.renewTimer = d.local.Pool.clock.AfterFunc(d.leaseTimeout/3, func() {
+		// a failed renewal is retried in the next period, before the leases expire
+		_ = d.Renew()
+
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		if !d.closed {
+			d.scheduleRenew()
+		}
+	})
+}
+
+// Close stops renewing and returns all blocks of the replica to the store, e.g. on shutdown
+func (d *DistributedPool) Close() error {
+	d.mtx.Lock()
+	if d.closed {
+		d.mtx.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.renewTimer.Stop()
+
+	var returnErr error
+	blocks := make([]Range, 0, len(d.blocks))
+	for _, block := range d.blocks {
+		blocks = append(blocks, block)
+		if err := d.removeBlock(block); err != nil && returnErr == nil {
+			returnErr = errors.Wrapf(err, "DistributedPool close")
+		}
+	}
+	d.mtx.Unlock()
+
+	for _, block := range blocks {
+		if err := d.store.Return(block, d.owner); err != nil && returnErr == nil {
+			returnErr = errors.Wrapf(err, "DistributedPool close")
+		}
+	}
+	return returnErr
+}
+
+// claim claims a new block from the store, it is called without the lock
+func (d *DistributedPool) claim() error {
+	for {
+		now := d.now()
+		block, ok, err := d.store.Claim(d.owner, now, now.Add(d.leaseTimeout))
+		if err != nil {
+			return errors.Wrapf(err, "DistributedPool claim")
+		}
+		if !ok {
+			return errors.Errorf("Pool is empty: %+v", d.local.Subnet())
+		}
+
+		d.mtx.Lock()
+		closed := d.closed
+		_, owned := d.blocks[block.First]
+		if !closed && !owned {
+			err = d.addBlock(block)
+		}
+		d.mtx.Unlock()
+
+		switch {
+		case closed:
+			// the other blocks have been returned by Close
+			if err = d.store.Return(block, d.owner); err != nil {
+				return errors.Wrapf(err, "DistributedPool claim")
+			}
+			return errClosed
+		case owned:
+			// a block of the replica whose lease expired before renewal, the claim renewed it
+			continue
+		default:
+			return err
+		}
+	}
+}
+
+// addBlock makes the usable values of block allocatable
+func (d *DistributedPool) addBlock(block Range) error {
+	if !d.local.Pool.Contains(block.First, block.Last) {
+		return errors.Errorf("Block %s is out of Pool[%+v]", block, d.local.Subnet())
+	}
+	d.blocks[block.First] = block
+	for _, r := range intersectRanges([]Range{block}, d.usable) {
+		d.local.Pool.releaseRange(r)
+	}
+	return nil
+}
+
+// removeBlock makes the values of block not allocatable
+func (d *DistributedPool) removeBlock(block Range) error {
+	delete(d.blocks, block.First)
+	return d.local.Pool.Reserve(block.First, block.Last)
+}
+
+// checkOwned returns an error if ip is not in a block of the replica
+func (d *DistributedPool) checkOwned(ip net.IP) error {
+	value, err := d.local.valueOf(ip)
+	if err != nil {
+		return err
+	}
+	first := d.local.Pool.Min() + (value-d.local.Pool.Min())/d.blockSize*d.blockSize
+	if _, ok := d.blocks[first]; !ok {
+		return errors.Errorf("IP[%s] is not in the blocks of %s", ip, d.owner)
+	}
+	return nil
+}
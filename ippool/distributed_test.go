@@ -0,0 +1,203 @@
+package ippool
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistributedPool(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryBlockStore()
+	newReplica := func(owner string) *DistributedPool {
+		d, err := NewDistributedPool("10.10.0.0/28", store, owner, 4, time.Minute, WithClock(clock))
+		require.NoError(t, err)
+		return d
+	}
+	ipOf := func(s string) net.IP {
+		return net.ParseIP(s).To4()
+	}
+	blockOf := func(first, last string) Range {
+		return Range{First: int(binary.BigEndian.Uint32(ipOf(first))), Last: int(binary.BigEndian.Uint32(ipOf(last)))}
+	}
+
+	a, b := newReplica("smf-a"), newReplica("smf-b")
+
+	// the replicas allocate from disjoint blocks, the network id and broadcast address are skipped
+	ip, err := a.Allocate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, ipOf("10.10.0.1"), ip)
+	ip, err = b.Allocate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, ipOf("10.10.0.4"), ip)
+	for _, expected := range []string{"10.10.0.2", "10.10.0.3", "10.10.0.8"} {
+		ip, err = a.Allocate(nil)
+		require.NoError(t, err)
+		assert.Equal(t, ipOf(expected), ip)
+	}
+	assert.Equal(t, []Range{blockOf("10.10.0.0", "10.10.0.3"), blockOf("10.10.0.8", "10.10.0.11")}, a.Blocks())
+	assert.Equal(t, []Range{blockOf("10.10.0.4", "10.10.0.7")}, b.Blocks())
+
+	_, err = a.Allocate(ipOf("10.10.0.5"))
+	require.Error(t, err)
+	require.Error(t, a.Release(ipOf("10.10.0.4")))
+
+	// the blocks are renewed in the background
+	clock.Advance(10 * time.Minute)
+	assert.Len(t, a.Blocks(), 2)
+	assert.Len(t, b.Blocks(), 1)
+
+	// b hangs, its block is claimed by a after the lease expires
+	b.renewTimer.Stop()
+	var lost []Range
+	b.OnBlockLost(func(block Range) {
+		lost = append(lost, block)
+	})
+	clock.Advance(2 * time.Minute)
+	for i := 0; i < 10; i++ {
+		_, err = a.Allocate(nil)
+		require.NoError(t, err)
+	}
+	assert.Len(t, a.Blocks(), 4)
+	_, err = a.Allocate(nil)
+	require.Error(t, err)
+
+	require.NoError(t, b.Renew())
+	assert.Equal(t, []Range{blockOf("10.10.0.4", "10.10.0.7")}, lost)
+	assert.Empty(t, b.Blocks())
+	require.Error(t, b.Release(ipOf("10.10.0.4")))
+	require.NoError(t, b.Close())
+
+	// a restarts and takes back its blocks
+	a.renewTimer.Stop()
+	a = newReplica("smf-a")
+	assert.Len(t, a.Blocks(), 4)
+	ip, inUsed := a.Reallocate(ipOf("10.10.0.1"))
+	require.False(t, inUsed)
+	assert.Equal(t, ipOf("10.10.0.1"), ip)
+	require.NoError(t, a.Release(ip))
+
+	// the blocks are returned on close
+	require.NoError(t, a.Close())
+	assert.Empty(t, a.Blocks())
+	_, err = a.Allocate(nil)
+	require.Error(t, err)
+	c := newReplica("smf-c")
+	ip, err = c.Allocate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, ipOf("10.10.0.1"), ip)
+	require.NoError(t, c.Close())
+}
+
+// blockingStore is a MemoryBlockStore whose Renew waits for resume once renewing is signaled
+type blockingStore struct {
+	*MemoryBlockStore
+	renewing chan struct{}
+	resume   chan struct{}
+}
+
+func (s *blockingStore) Renew(block Range, owner string, expires time.Time) (bool, error) {
+	if s.renewing != nil {
+		close(s.renewing)
+		s.renewing = nil
+		<-s.resume
+	}
+	return s.MemoryBlockStore.Renew(block, owner, expires)
+}
+
+func TestDistributedPool_RenewWithoutLock(t *testing.T) {
+	clock := newFakeClock()
+	store := &blockingStore{MemoryBlockStore: NewMemoryBlockStore()}
+	d, err := NewDistributedPool("10.10.0.0/28", store, "smf", 4, time.Minute, WithClock(clock))
+	require.NoError(t, err)
+	ip, err := d.Allocate(nil)
+	require.NoError(t, err)
+
+	renewing := make(chan struct{})
+	store.renewing, store.resume = renewing, make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- d.Renew()
+	}()
+	<-renewing
+
+	// the pool is usable while the store renews, a new block is claimed
+	require.NoError(t, d.Release(ip))
+	for i := 0; i < 4; i++ {
+		_, err = d.Allocate(nil)
+		require.NoError(t, err)
+	}
+	assert.Len(t, d.Blocks(), 2)
+
+	close(store.resume)
+	require.NoError(t, <-done)
+	assert.Len(t, d.Blocks(), 2)
+	require.NoError(t, d.Close())
+}
+
+func TestDistributedPool_InvalidBlockSize(t *testing.T) {
+	_, err := NewDistributedPool("10.10.0.0/8", NewMemoryBlockStore(), "smf", 4, time.Minute)
+	require.Error(t, err)
+	_, err = NewDistributedPool("10.10.0.0/28", NewMemoryBlockStore(), "smf", 0, time.Minute)
+	require.Error(t, err)
+}
+
+func TestDistributedPool_RestartAfterLeaseTimeout(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryBlockStore()
+	d, err := NewDistributedPool("10.10.0.0/28", store, "smf", 4, time.Minute, WithClock(clock))
+	require.NoError(t, err)
+	ip, err := d.Allocate(nil)
+	require.NoError(t, err)
+
+	// the replica is down longer than the lease timeout
+	d.renewTimer.Stop()
+	clock.Advance(time.Hour)
+
+	d, err = NewDistributedPool("10.10.0.0/28", store, "smf", 4, time.Minute, WithClock(clock))
+	require.NoError(t, err)
+	assert.Len(t, d.Blocks(), 1)
+	_, inUsed := d.Reallocate(ip)
+	require.False(t, inUsed)
+	for i := 0; i < 13; i++ {
+		_, err = d.Allocate(nil)
+		require.NoError(t, err)
+	}
+	assert.Len(t, d.Blocks(), 4)
+	_, err = d.Allocate(nil)
+	require.Error(t, err)
+
+	// the expired blocks of the replica are claimed again, without freeing their allocated addresses
+	d.renewTimer.Stop()
+	clock.Advance(time.Hour)
+	_, err = d.Allocate(nil)
+	require.Error(t, err)
+	assert.Len(t, d.Blocks(), 4)
+	require.NoError(t, d.Release(ip))
+	allocated, err := d.Allocate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, ip, allocated)
+	require.NoError(t, d.Close())
+}
+
+func TestDistributedPool_IPv6(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryBlockStore()
+	a, err := NewDistributedPool("2001:db8::/66", store, "smf-a", 1<<48, time.Minute, WithClock(clock))
+	require.NoError(t, err)
+	b, err := NewDistributedPool("2001:db8::/66", store, "smf-b", 1<<48, time.Minute, WithClock(clock))
+	require.NoError(t, err)
+
+	ip, err := a.Allocate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, net.ParseIP("2001:db8::1"), ip)
+	ip, err = b.Allocate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, net.ParseIP("2001:db8::1:0:0:0"), ip)
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+}
@@ -3,28 +3,61 @@ package ippool
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
+	"math/big"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"github.com/pkg/errors"
 )
 
+// IPPool allocates addresses of its subnet, or IPv6 prefixes of its subnet (see NewIPv6PrefixPool).
+//
+// Each value of Pool stands for one allocation unit: for IPv4 the value is the address itself,
+// for IPv6 it is the offset of the address (or prefix) from the beginning of the subnet.
 type IPPool struct {
+	// IPSubnet is the subnet the pool is made of, it is not updated by Resize.
+	//
+	// Deprecated: use Subnet, which returns the subnet after Resize.
 	IPSubnet *net.IPNet
 	Pool     *LazyReusePool
+	// subnet is set by Resize, IPSubnet is used until then
+	subnet atomic.Pointer[net.IPNet]
+	// base is the address of value 0, unitBits is the number of host bits in one allocation unit
+	base      *big.Int
+	unitBits  int
+	prefixLen int
+	// reserveAnycast is true if the Subnet-Router anycast address of IPv6 is not allocated
+	reserveAnycast bool
+	// metrics is set by Metrics.AddPool
+	metrics atomic.Pointer[metricsBinding]
+	// static reservations, see AddStaticIP
+	staticMtx sync.Mutex
+	statics   map[string]*staticIP
+	staticOf  map[int]*staticIP
 }
 
-func NewIPPool(cidr string) (*IPPool, error) {
+// NewIPPool makes an IPPool allocating single addresses of an IPv4 or IPv6 CIDR.
+// The network id and broadcast address of IPv4 and the Subnet-Router anycast address
+// of IPv6 are not allocated.
+func NewIPPool(cidr string, opts ...PoolOption) (*IPPool, error) {
 	_, ipNet, err := net.ParseCIDR(cidr)
 	if err != nil {
 		return nil, errors.Wrapf(err, "NewIPPool ParseCIDR")
 	}
 
+	if ipNet.IP.To4() == nil {
+		return newIPv6Pool(cidr, ipNet, 8*net.IPv6len, true, opts)
+	}
+
 	minAddr, maxAddr, err := calcAddrRange(ipNet)
 	if err != nil {
 		return nil, errors.Wrapf(err, "NewIPPool calcAddrRange")
 	}
 
-	newPool, err := NewLazyReusePool(int(minAddr), int(maxAddr))
+	newPool, err := NewLazyReusePool(int(minAddr), int(maxAddr), opts...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "NewIPPool NewLazyReusePool")
 	}
@@ -36,7 +69,71 @@ func NewIPPool(cidr string) (*IPPool, error) {
 		return nil, errors.Wrapf(err, "Remove broadcasting address from pool failed for %s", cidr)
 	}
 
-	return &IPPool{IPSubnet: ipNet, Pool: newPool}, nil
+	return &IPPool{
+		IPSubnet:  ipNet,
+		Pool:      newPool,
+		base:      new(big.Int),
+		prefixLen: 8 * net.IPv4len,
+	}, nil
+}
+
+// NewIPv6PrefixPool makes an IPPool allocating whole prefixes of prefixLen from an IPv6 CIDR,
+// e.g. /64 prefixes for IPv6 prefix delegation. Allocate, Reallocate and Release take and return
+// the first address of a prefix.
+func NewIPv6PrefixPool(cidr string, prefixLen int, opts ...PoolOption) (*IPPool, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewIPv6PrefixPool ParseCIDR")
+	}
+	if ipNet.IP.To4() != nil {
+		return nil, errors.Errorf("NewIPv6PrefixPool: %s is not an IPv6 CIDR", cidr)
+	}
+	return newIPv6Pool(cidr, ipNet, prefixLen, false, opts)
+}
+
+// newIPv6Pool makes a pool of the prefixes of prefixLen in ipNet.
+// The number of prefixes must not exceed 2^62 (2^30 on 32-bit platforms) to be counted by an int.
+func newIPv6Pool(
+	cidr string,
+	ipNet *net.IPNet,
+	prefixLen int,
+	reserveAnycast bool,
+	opts []PoolOption,
+) (*IPPool, error) {
+	ones, bits := ipNet.Mask.Size()
+	if prefixLen < ones || prefixLen > bits {
+		return nil, errors.Errorf("prefix length %d is invalid for %s", prefixLen, cidr)
+	}
+
+	unitCountBits := prefixLen - ones
+	if unitCountBits > strconv.IntSize-2 {
+		return nil, errors.Errorf("%s has 2^%d prefixes of length %d, more than a pool can manage",
+			cidr, unitCountBits, prefixLen)
+	}
+	maxValue := 1<<unitCountBits - 1
+	if reserveAnycast && maxValue == 0 {
+		return nil, errors.Errorf("mask is invalid for %s", cidr)
+	}
+
+	newPool, err := NewLazyReusePool(0, maxValue, opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewIPPool NewLazyReusePool")
+	}
+
+	if reserveAnycast {
+		if err := newPool.Reserve(0, 0); err != nil {
+			return nil, errors.Wrapf(err, "Remove Subnet-Router anycast address from pool failed for %s", cidr)
+		}
+	}
+
+	return &IPPool{
+		IPSubnet:       ipNet,
+		Pool:           newPool,
+		base:           new(big.Int).SetBytes(ipNet.IP.To16()),
+		unitBits:       bits - prefixLen,
+		prefixLen:      prefixLen,
+		reserveAnycast: reserveAnycast,
+	}, nil
 }
 
 func calcAddrRange(ipNet *net.IPNet) (minAddr, maxAddr uint32, err error) {
@@ -51,43 +148,165 @@ func calcAddrRange(ipNet *net.IPNet) (minAddr, maxAddr uint32, err error) {
 	return minAddr, maxAddr, nil
 }
 
+// Subnet returns the current subnet of the pool, which is IPSubnet unless the pool is resized
+func (p *IPPool) Subnet() *net.IPNet {
+	if subnet := p.subnet.Load(); subnet != nil {
+		return subnet
+	}
+	return p.IPSubnet
+}
+
+// IsIPv4 return true if the pool allocates IPv4 addresses
+func (p *IPPool) IsIPv4() bool {
+	return p.Subnet().IP.To4() != nil
+}
+
+// PrefixLen returns the prefix length of allocated units, 32 or 128 for pools of single addresses
+func (p *IPPool) PrefixLen() int {
+	return p.prefixLen
+}
+
+// addrOf converts ip into an integer, ok is false if ip is not in the address family of the pool
+func (p *IPPool) addrOf(ip net.IP) (addr *big.Int, ok bool) {
+	if p.IsIPv4() {
+		ip = ip.To4()
+	} else if ip.To4() == nil {
+		ip = ip.To16()
+	} else {
+		return nil, false
+	}
+	if ip == nil {
+		return nil, false
+	}
+	return new(big.Int).SetBytes(ip), true
+}
+
+// valueOfAddr converts an address into the value of the unit containing it
+func (p *IPPool) valueOfAddr(addr *big.Int) (int, bool) {
+	offset := new(big.Int).Sub(addr, p.base)
+	if offset.Sign() < 0 {
+		return 0, false
+	}
+	offset.Rsh(offset, uint(p.unitBits))
+	if !offset.IsInt64() || offset.Int64() > math.MaxInt {
+		return 0, false
+	}
+	return int(offset.Int64()), true
+}
+
+// valueOf converts ip into the value of the unit containing it
+func (p *IPPool) valueOf(ip net.IP) (int, error) {
+	addr, ok := p.addrOf(ip)
+	if !ok {
+		return 0, errors.Errorf("invalid Address: %s", ip)
+	}
+	value, ok := p.valueOfAddr(addr)
+	if !ok || !p.Pool.Contains(value, value) {
+		return 0, errors.Errorf("Address %s is out of Pool[%+v]", ip, p.Subnet())
+	}
+	return value, nil
+}
+
+// addrOfValue returns the first address of the unit of value
+func (p *IPPool) addrOfValue(value int) *big.Int {
+	addr := new(big.Int).Lsh(big.NewInt(int64(value)), uint(p.unitBits))
+	return addr.Add(addr, p.base)
+}
+
+// ipOf returns the first address of the unit of value
+func (p *IPPool) ipOf(value int) net.IP {
+	if p.IsIPv4() {
+		return uint32ToIP(uint32(value)) // #nosec G115
+	}
+	return p.addrOfValue(value).FillBytes(make([]byte, net.IPv6len))
+}
+
+// lastAddrOfValue returns the last address of the unit of value
+func (p *IPPool) lastAddrOfValue(value int) *big.Int {
+	addr := p.addrOfValue(value + 1)
+	return addr.Sub(addr, big.NewInt(1))
+}
+
 func (p *IPPool) Reallocate(request net.IP) (net.IP, bool) {
-	var allocVal int
 	if request == nil {
 		return nil, false
 	}
-	allocVal = int(binary.BigEndian.Uint32(request))
+	allocVal, err := p.valueOf(request)
+	if err != nil {
+		return request, true
+	}
 	ok := p.Pool.Use(allocVal)
 	inUsed := !ok
-	return uint32ToIP(uint32(allocVal)), inUsed // #nosec G115
+	p.report(false)
+	return p.ipOf(allocVal), inUsed
 }
 
 func (p *IPPool) Allocate(request net.IP) (net.IP, error) {
+	ip, err := p.allocate(request)
+	p.report(err != nil)
+	return ip, err
+}
+
+func (p *IPPool) allocate(request net.IP) (net.IP, error) {
 	var allocVal int
-	var ok bool
+	var err error
 	if request != nil {
-		allocVal = int(binary.BigEndian.Uint32(request))
-		ok = p.Pool.Use(allocVal)
-		if !ok {
-			return nil, errors.Errorf("IP[%s] is used in Pool[%+v]", request, p.IPSubnet)
+		allocVal, err = p.valueOf(request)
+		if err != nil {
+			return nil, err
+		}
+		if _, static := p.staticOwner(allocVal); static {
+			return nil, errors.Errorf("IP[%s] is statically reserved in Pool[%+v]", request, p.Subnet())
+		}
+		if err = p.Pool.useValue(allocVal); err != nil {
+			return nil, p.allocationError(err, request)
 		}
 		// if allocated request IP address
 		goto RETURNIP
 	}
 
-	allocVal, ok = p.Pool.Allocate()
-	if !ok {
-		return nil, errors.Errorf("Pool is empty: %+v", p.IPSubnet)
+	allocVal, err = p.Pool.allocateValue()
+	if err != nil {
+		return nil, p.allocationError(err, nil)
 	}
 
 RETURNIP:
-	retIP := uint32ToIP(uint32(allocVal)) // #nosec G115
+	retIP := p.ipOf(allocVal)
 	return retIP, nil
 }
 
+// allocationError returns the error of allocating request, or any address if request is nil,
+// from the error of the allocation in Pool. The journal failures are returned as is.
+func (p *IPPool) allocationError(err error, request net.IP) error {
+	switch {
+	case errors.Is(err, ErrJournal):
+		return errors.Wrapf(err, "Pool[%+v]", p.Subnet())
+	case request != nil:
+		return errors.Errorf("IP[%s] is used in Pool[%+v]", request, p.Subnet())
+	default:
+		return errors.Errorf("Pool is empty: %+v", p.Subnet())
+	}
+}
+
+// AllocatePrefix is Allocate returning the allocated unit with its prefix length
+func (p *IPPool) AllocatePrefix(request net.IP) (*net.IPNet, error) {
+	ip, err := p.Allocate(request)
+	if err != nil {
+		return nil, err
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(p.prefixLen, 8*len(ip))}, nil
+}
+
 func (p *IPPool) Exclude(excludePool *IPPool) error {
-	excludeMin := excludePool.Pool.Min()
-	excludeMax := excludePool.Pool.Max()
+	if p.IsIPv4() != excludePool.IsIPv4() {
+		return errors.Errorf("exclude uePool fail: %+v and %+v are not the same address family",
+			p.Subnet(), excludePool.Subnet())
+	}
+	excludeMin, okMin := p.valueOfAddr(excludePool.addrOfValue(excludePool.Pool.Min()))
+	excludeMax, okMax := p.valueOfAddr(excludePool.lastAddrOfValue(excludePool.Pool.Max()))
+	if !okMin || !okMax {
+		return errors.Errorf("exclude uePool fail: %+v is out of %+v", excludePool.Subnet(), p.Subnet())
+	}
 	if err := p.Pool.Reserve(excludeMin, excludeMax); err != nil {
 		return errors.Errorf("exclude uePool fail: %v", err)
 	}
@@ -104,11 +323,20 @@ func (p *IPPool) Release(ip net.IP) error {
 	if len(ip) < net.IPv4len {
 		return errors.Errorf("failed to release invalid Address: %s", ip)
 	}
-	addrVal := binary.BigEndian.Uint32(ip)
-	res := p.Pool.Free(int(addrVal))
-	if !res {
+	addrVal, err := p.valueOf(ip)
+	if err != nil {
+		return errors.Wrapf(err, "failed to release UE Address")
+	}
+	if supi, static := p.staticOwner(addrVal); static {
+		return errors.Errorf("failed to release UE Address: %s is statically reserved for %s", ip, supi)
+	}
+	if err = p.Pool.freeValue(addrVal); err != nil {
+		if errors.Is(err, ErrJournal) {
+			return errors.Wrapf(err, "failed to release UE Address: %s", ip)
+		}
 		return errors.Errorf("failed to release UE Address: %s", ip)
 	}
+	p.report(false)
 	return nil
 }
 
@@ -116,14 +344,8 @@ func (p *IPPool) String() string {
 	str := "["
 	elements := p.Pool.Dump()
 	for index, element := range elements {
-		var firstAddr net.IP
-		var lastAddr net.IP
-		buf := make([]byte, 4)
-		binary.BigEndian.PutUint32(buf, uint32(element[0])) // #nosec G115
-		firstAddr = buf
-		buf = make([]byte, 4)
-		binary.BigEndian.PutUint32(buf, uint32(element[1])) // #nosec G115
-		lastAddr = buf
+		firstAddr := p.ipOf(element[0])
+		lastAddr := p.ipOf(element[1])
 		if index > 0 {
 			str += ("->")
 		}
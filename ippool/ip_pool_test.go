@@ -130,3 +130,91 @@ func TestIpPool_ExcludeRange(t *testing.T) {
 		require.NoError(t, err)
 	}
 }
+
+func TestIPPool_IPv6(t *testing.T) {
+	// invalid MaskLen
+	_, err := NewIPPool("2001:db8::/128")
+	require.Error(t, err)
+
+	ipPool, err := NewIPPool("2001:db8::/120")
+	require.NoError(t, err)
+	require.False(t, ipPool.IsIPv4())
+	require.Equal(t, 128, ipPool.PrefixLen())
+	// Not contains Subnet-Router anycast address
+	require.Equal(t, 255, ipPool.Pool.Remain())
+
+	for i := 1; i <= 255; i++ {
+		allocIP, err := ipPool.Allocate(nil)
+		require.NoError(t, err)
+		require.Equal(t, net.ParseIP(fmt.Sprintf("2001:db8::%x", i)), allocIP)
+	}
+	_, err = ipPool.Allocate(nil)
+	require.Error(t, err)
+
+	require.NoError(t, ipPool.Release(net.ParseIP("2001:db8::10")))
+	require.Error(t, ipPool.Release(net.ParseIP("2001:db8::10")))
+	require.Error(t, ipPool.Release(net.ParseIP("2001:db8::1:0")))
+	require.Error(t, ipPool.Release(net.ParseIP("10.10.0.1")))
+	require.Equal(t, "[{2001:db8::10 - 2001:db8::10}]", ipPool.String())
+
+	reAllocIP, used := ipPool.Reallocate(net.ParseIP("2001:db8::10"))
+	require.False(t, used)
+	require.Equal(t, net.ParseIP("2001:db8::10"), reAllocIP)
+
+	// an address out of the pool is reported as used
+	reAllocIP, used = ipPool.Reallocate(net.ParseIP("2001:db8::1:0"))
+	require.True(t, used)
+	require.Equal(t, net.ParseIP("2001:db8::1:0"), reAllocIP)
+
+	// a /64 has more addresses than int can count
+	_, err = NewIPPool("2001:db8::/64")
+	require.Error(t, err)
+	_, err = NewIPPool("2001:db8::/65")
+	require.Error(t, err)
+	ipPool, err = NewIPPool("2001:db8::/66")
+	require.NoError(t, err)
+	require.Equal(t, 1<<62-1, ipPool.Pool.Remain())
+	allocIP, err := ipPool.Allocate(net.ParseIP("2001:db8::3fff:ffff:ffff:ffff"))
+	require.NoError(t, err)
+	require.Equal(t, net.ParseIP("2001:db8::3fff:ffff:ffff:ffff"), allocIP)
+}
+
+func TestIPPool_IPv6Prefix(t *testing.T) {
+	_, err := NewIPv6PrefixPool("10.10.0.0/16", 24)
+	require.Error(t, err)
+	_, err = NewIPv6PrefixPool("2001:db8::/64", 48)
+	require.Error(t, err)
+
+	ipPool, err := NewIPv6PrefixPool("2001:db8::/62", 64)
+	require.NoError(t, err)
+	require.Equal(t, 64, ipPool.PrefixLen())
+	require.Equal(t, 4, ipPool.Pool.Total())
+	require.Equal(t, 4, ipPool.Pool.Remain())
+
+	prefix, err := ipPool.AllocatePrefix(nil)
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8::/64", prefix.String())
+
+	// any address of a prefix stands for the prefix
+	prefix, err = ipPool.AllocatePrefix(net.ParseIP("2001:db8:0:2::1"))
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8:0:2::/64", prefix.String())
+
+	allocIP, err := ipPool.Allocate(nil)
+	require.NoError(t, err)
+	require.Equal(t, net.ParseIP("2001:db8:0:1::"), allocIP)
+	require.Equal(t, "[{2001:db8:0:3:: - 2001:db8:0:3::}]", ipPool.String())
+
+	require.NoError(t, ipPool.Release(net.ParseIP("2001:db8:0:2::")))
+	require.Equal(t, 2, ipPool.Pool.Remain())
+
+	// exclude a /63 made of 2 prefixes
+	excludePool, err := NewIPv6PrefixPool("2001:db8:0:2::/63", 64)
+	require.NoError(t, err)
+	require.NoError(t, ipPool.Exclude(excludePool))
+	require.Equal(t, 0, ipPool.Pool.Remain())
+
+	v4Pool, err := NewIPPool("10.10.0.0/24")
+	require.NoError(t, err)
+	require.Error(t, ipPool.Exclude(v4Pool))
+}
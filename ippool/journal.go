@@ -0,0 +1,239 @@
+package ippool
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Journal persists the values allocated from a LazyReusePool, so the pool can be rebuilt
+// after a restart, see LazyReusePool.Restore.
+//
+// Allocated and Released are called with the lock of the pool held, before the pool is updated;
+// if they fail, the allocation or release fails.
+type Journal interface {
+	// Allocated records that value is taken out of the pool
+	Allocated(value int) error
+	// Released records that value is returned to the pool
+	Released(value int) error
+	// Load returns the values recorded as allocated and not released
+	Load() ([]int, error)
+}
+
+// ErrJournal is wrapped by the errors of the allocations and releases which cannot be journaled,
+// so they can be told from an exhausted pool
+var ErrJournal = errors.New("Pool journal failed")
+
+// journalError wraps err of Journal with ErrJournal
+func journalError(err error) error {
+	return fmt.Errorf("%w: %w", ErrJournal, err)
+}
+
+// Restore takes the values loaded from journal out of the pool, then records
+// all following allocations and releases of the pool in journal.
+// The values reserved by Reserve must be reserved again before Restore, they are not journaled.
+func (p *LazyReusePool) Restore(journal Journal) ([]int, error) {
+	values, err := journal.Load()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Restore")
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	// check all values before updating the pool, so the pool is unchanged on failure
+	seen := make(map[int]bool, len(values))
+	for _, value := range values {
+		if value < p.first || p.last < value {
+			return nil, errors.Errorf("Journaled value %d is out of pool[%d, %d]", value, p.first, p.last)
+		}
+		if seen[value] || !p.isFree(value) {
+			return nil, errors.Errorf("Journaled value %d is not free", value)
+		}
+		seen[value] = true
+	}
+	for _, value := range values {
+		p.use(value)
+		p.track(value)
+	}
+	p.journal = journal
+	return values, nil
+}
+
+// Restore rebuilds the pool from journal and returns the restored addresses, see LazyReusePool.Restore
+func (p *IPPool) Restore(journal Journal) ([]net.IP, error) {
+	values, err := p.Pool.Restore(journal)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(values))
+	for _, value := range values {
+		ips = append(ips, p.ipOf(value))
+	}
+	return ips, nil
+}
+
+// FileJournal is a Journal writing ahead to a local file.
+//
+// Each allocation or release appends one line and is synced to disk before the pool is updated.
+// A line partially written by a crash is discarded by Load. Compact shrinks the file.
+type FileJournal struct {
+	mtx  sync.Mutex
+	path string
+	file *os.File
+}
+
+const (
+	fileJournalAllocated = '+'
+	fileJournalReleased  = '-'
+)
+
+// NewFileJournal opens the journal at path, the file is created if it does not exist
+func NewFileJournal(path string) (*FileJournal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewFileJournal")
+	}
+	return &FileJournal{path: path, file: file}, nil
+}
+
+func (j *FileJournal) Allocated(value int) error {
+	return j.append(fileJournalAllocated, value)
+}
+
+func (j *FileJournal) Released(value int) error {
+	return j.append(fileJournalReleased, value)
+}
+
+func (j *FileJournal) append(op byte, value int) error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if _, err := fmt.Fprintf(j.file, "%c%d\n", op, value); err != nil {
+		return errors.Wrapf(err, "FileJournal append")
+	}
+	if err := j.file.Sync(); err != nil {
+		return errors.Wrapf(err, "FileJournal sync")
+	}
+	return nil
+}
+
+// Load replays the file and returns the allocated values in ascending order.
+// An incomplete last line is truncated from the file.
+func (j *FileJournal) Load() ([]int, error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	values, err := j.load()
+	if err != nil {
+		return nil, err
+	}
+	return sortedValues(values), nil
+}
+
+func (j *FileJournal) load() (map[int]bool, error) {
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "FileJournal load")
+	}
+
+	if complete := bytes.LastIndexByte(data, '\n') + 1; complete < len(data) {
+		if err = os.Truncate(j.path, int64(complete)); err != nil {
+			return nil, errors.Wrapf(err, "FileJournal truncate")
+		}
+		data = data[:complete]
+	}
+
+	values := make(map[int]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		record := scanner.Text()
+		if len(record) < 2 {
+			return nil, errors.Errorf("Corrupted journal %s at line %d: %q", j.path, line, record)
+		}
+		value, err := strconv.Atoi(record[1:])
+		if err != nil {
+			return nil, errors.Errorf("Corrupted journal %s at line %d: %q", j.path, line, record)
+		}
+		switch record[0] {
+		case fileJournalAllocated:
+			values[value] = true
+		case fileJournalReleased:
+			delete(values, value)
+		default:
+			return nil, errors.Errorf("Corrupted journal %s at line %d: %q", j.path, line, record)
+		}
+	}
+	return values, scanner.Err()
+}
+
+// Compact rewrites the file with only the allocated values.
+// The new file is written aside and renamed, so the journal is intact if Compact fails.
+// The new file is opened for appending before the rename, so the journal keeps appending
+// to the renamed file.
+func (j *FileJournal) Compact() error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	values, err := j.load()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0o600)
+	if err != nil {
+		return errors.Wrapf(err, "FileJournal compact")
+	}
+	writer := bufio.NewWriter(tmp)
+	for _, value := range sortedValues(values) {
+		if _, err = fmt.Fprintf(writer, "%c%d\n", fileJournalAllocated, value); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, j.path)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return errors.Wrapf(err, "FileJournal compact")
+	}
+
+	// the old file is replaced, so the journal switches to the new one even if the close fails
+	old := j.file
+	j.file = tmp
+	if err = old.Close(); err != nil {
+		return errors.Wrapf(err, "FileJournal compact")
+	}
+	return nil
+}
+
+// Close closes the file, the journal must not be used after Close
+func (j *FileJournal) Close() error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	return j.file.Close()
+}
+
+func sortedValues(values map[int]bool) []int {
+	sorted := make([]int, 0, len(values))
+	for value := range values {
+		sorted = append(sorted, value)
+	}
+	sort.Ints(sorted)
+	return sorted
+}
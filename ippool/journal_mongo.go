@@ -0,0 +1,57 @@
+package ippool
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/free5gc/util/mongoapi"
+)
+
+// MongoJournal is a Journal keeping one document per allocated value in a MongoDB collection
+// via mongoapi, several pools can share a collection with different pool names.
+// mongoapi.SetMongoDB must be called before using it.
+type MongoJournal struct {
+	collName string
+	pool     string
+}
+
+// NewMongoJournal makes a MongoJournal of the pool named pool in collection collName
+func NewMongoJournal(collName, pool string) *MongoJournal {
+	return &MongoJournal{collName: collName, pool: pool}
+}
+
+func (j *MongoJournal) filterOf(value int) bson.M {
+	return bson.M{"pool": j.pool, "value": value}
+}
+
+func (j *MongoJournal) Allocated(value int) error {
+	putData := map[string]interface{}{"pool": j.pool, "value": value}
+	if _, err := mongoapi.RestfulAPIPutOne(j.collName, j.filterOf(value), putData); err != nil {
+		return errors.Wrapf(err, "MongoJournal allocated %d", value)
+	}
+	return nil
+}
+
+func (j *MongoJournal) Released(value int) error {
+	if err := mongoapi.RestfulAPIDeleteOne(j.collName, j.filterOf(value)); err != nil {
+		return errors.Wrapf(err, "MongoJournal released %d", value)
+	}
+	return nil
+}
+
+func (j *MongoJournal) Load() ([]int, error) {
+	docs, err := mongoapi.RestfulAPIGetMany(j.collName, bson.M{"pool": j.pool})
+	if err != nil {
+		return nil, errors.Wrapf(err, "MongoJournal load")
+	}
+
+	values := make(map[int]bool, len(docs))
+	for _, doc := range docs {
+		value, ok := intOf(doc["value"])
+		if !ok {
+			return nil, errors.Errorf("MongoJournal load: invalid value %v of pool %s", doc["value"], j.pool)
+		}
+		values[value] = true
+	}
+	return sortedValues(values), nil
+}
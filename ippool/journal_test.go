@@ -0,0 +1,164 @@
+package ippool
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPPool_FileJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool.journal")
+
+	journal, err := NewFileJournal(path)
+	require.NoError(t, err)
+	pool, err := NewIPPool("10.10.0.0/24")
+	require.NoError(t, err)
+	ips, err := pool.Restore(journal)
+	require.NoError(t, err)
+	require.Empty(t, ips)
+
+	for i := 0; i < 3; i++ {
+		_, err = pool.Allocate(nil)
+		require.NoError(t, err)
+	}
+	_, err = pool.Allocate(net.ParseIP("10.10.0.100"))
+	require.NoError(t, err)
+	require.NoError(t, pool.Release(net.ParseIP("10.10.0.2")))
+	// failed operations are not journaled
+	require.Error(t, pool.Release(net.ParseIP("10.10.0.2")))
+	_, err = pool.Allocate(net.ParseIP("10.10.0.100"))
+	require.Error(t, err)
+	require.NoError(t, journal.Close())
+
+	// rebuild after restart
+	journal, err = NewFileJournal(path)
+	require.NoError(t, err)
+	restored, err := NewIPPool("10.10.0.0/24")
+	require.NoError(t, err)
+	ips, err = restored.Restore(journal)
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{
+		net.ParseIP("10.10.0.1").To4(),
+		net.ParseIP("10.10.0.3").To4(),
+		net.ParseIP("10.10.0.100").To4(),
+	}, ips)
+	assert.Equal(t, pool.Pool.Remain(), restored.Pool.Remain())
+	_, inUse := restored.Reallocate(net.ParseIP("10.10.0.3"))
+	assert.True(t, inUse)
+
+	// compaction keeps the allocated values only
+	require.NoError(t, journal.Compact())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "+168427521\n+168427523\n+168427620\n", string(data))
+	require.NoError(t, restored.Release(net.ParseIP("10.10.0.1")))
+	values, err := journal.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []int{168427523, 168427620}, values)
+	// the records after compaction are appended to the new file
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "+168427521\n+168427523\n+168427620\n-168427521\n", string(data))
+
+	// a failed compaction keeps the journal
+	require.NoError(t, os.Mkdir(path+".tmp", 0o700))
+	require.Error(t, journal.Compact())
+	require.NoError(t, restored.Release(net.ParseIP("10.10.0.3")))
+	values, err = journal.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []int{168427620}, values)
+	require.NoError(t, journal.Close())
+}
+
+func TestFileJournal_Crash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool.journal")
+	require.NoError(t, os.WriteFile(path, []byte("+1\n+2\n-1\n+3"), 0o600))
+
+	journal, err := NewFileJournal(path)
+	require.NoError(t, err)
+	defer journal.Close()
+
+	// the incomplete record is discarded
+	values, err := journal.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, values)
+	require.NoError(t, journal.Allocated(4))
+	values, err = journal.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 4}, values)
+
+	require.NoError(t, os.WriteFile(path, []byte("+1\n*2\n"), 0o600))
+	_, err = journal.Load()
+	assert.ErrorContains(t, err, "Corrupted journal")
+}
+
+type failingJournal struct {
+	values []int
+	err    error
+}
+
+func (j *failingJournal) Allocated(value int) error { return j.err }
+func (j *failingJournal) Released(value int) error  { return j.err }
+func (j *failingJournal) Load() ([]int, error)      { return j.values, nil }
+
+func TestLazyReusePool_Journal(t *testing.T) {
+	journal := &failingJournal{values: []int{2, 5}}
+	p, err := NewLazyReusePool(1, 10)
+	require.NoError(t, err)
+	values, err := p.Restore(journal)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 5}, values)
+	assert.Equal(t, [][]int{{1, 1}, {3, 4}, {6, 10}}, p.Dump())
+
+	// the pool is unchanged if the journal fails
+	journal.err = errors.New("disk full")
+	_, ok := p.Allocate()
+	assert.False(t, ok)
+	assert.False(t, p.Use(3))
+	assert.False(t, p.Free(2))
+	assert.Equal(t, 8, p.Remain())
+	assert.Equal(t, [][]int{{1, 1}, {3, 4}, {6, 10}}, p.Dump())
+	_, err = p.allocateValue()
+	assert.ErrorIs(t, err, ErrJournal)
+	assert.ErrorContains(t, err, "disk full")
+
+	// journaled values must be free
+	p, err = NewLazyReusePool(1, 10)
+	require.NoError(t, err)
+	require.NoError(t, p.Reserve(5, 5))
+	_, err = p.Restore(journal)
+	assert.EqualError(t, err, "Journaled value 5 is not free")
+	_, err = p.Restore(&failingJournal{values: []int{11}})
+	assert.EqualError(t, err, "Journaled value 11 is out of pool[1, 10]")
+	assert.Equal(t, 9, p.Remain())
+}
+
+func TestIPPool_JournalError(t *testing.T) {
+	journal := &failingJournal{values: []int{168427521}}
+	pool, err := NewIPPool("10.10.0.0/24")
+	require.NoError(t, err)
+	_, err = pool.Restore(journal)
+	require.NoError(t, err)
+
+	// journal failures are not reported as an exhausted pool or a used address
+	journal.err = errors.New("disk full")
+	_, err = pool.Allocate(nil)
+	assert.ErrorIs(t, err, ErrJournal)
+	assert.NotContains(t, err.Error(), "Pool is empty")
+	_, err = pool.Allocate(net.ParseIP("10.10.0.2"))
+	assert.ErrorIs(t, err, ErrJournal)
+	_, err = pool.AllocateLease(nil, time.Minute)
+	assert.ErrorIs(t, err, ErrJournal)
+	assert.ErrorIs(t, pool.Release(net.ParseIP("10.10.0.1")), ErrJournal)
+
+	group, err := NewPoolGroup(FillFirst, pool)
+	require.NoError(t, err)
+	_, err = group.Allocate(nil)
+	assert.ErrorIs(t, err, ErrJournal)
+}
@@ -3,16 +3,54 @@ package ippool
 import (
 	"fmt"
 	"sync"
+	"time"
+
+	"github.com/free5gc/util/internal/clock"
+	"github.com/free5gc/util/leakcheck"
 )
 
+// LazyReusePool allocates values of [first, last] lazily: freed values are not reused until
+// the values of the head segment are allocated.
+//
+// The free values are kept as a list of segments: the head segment, from which values are
+// allocated, followed by the other segments in the order they are allocated in. The segments
+// after the head are indexed by their values in a segmentTree and by their order in an orderTree,
+// so Use, Free and Reserve take O(log n) for n segments.
 type LazyReusePool struct {
-	mtx    sync.Mutex
-	head   *segment // nil when empty
+	mtx  sync.Mutex
+	head *segment // nil when empty
+	// index and order hold the segments after head
+	index  segmentTree
+	order  orderTree
 	first  int
 	last   int
 	remain int
+	// reserved holds the normalized ranges taken by Reserve, which are not reported by Resize
+	reserved []Range
+	// journal is set by Restore, nil if the pool is not persisted
+	journal Journal
+	// clock drives quarantines and leases
+	clock Clock
+	// quarantine is the hold-down period of freed values, see WithQuarantine
+	quarantine  time.Duration
+	quarantined quarantineQueue
+	// leases stores the lease of each leased value, see AllocateLease
+	leases      map[int]*lease
+	leaseExpiry func(value int)
+	leaseFreed  func()
+	// tracker records the live allocations, see WithTracking
+	tracker *leakcheck.Tracker
 }
 
+var (
+	errPoolEmpty    = fmt.Errorf("pool is empty")
+	errNotFree      = fmt.Errorf("value is not free")
+	errNotAllocated = fmt.Errorf("value is not allocated")
+)
+
+// PoolOption configures an optional feature of LazyReusePool, see NewLazyReusePool
+type PoolOption func(*LazyReusePool)
+
 type segment struct {
 	first int
 	last  int
@@ -30,67 +68,219 @@ const (
 )
 
 // NewLazyReusePool makes a LazyReusePool.
-func NewLazyReusePool(first, last int) (*LazyReusePool, error) {
+func NewLazyReusePool(first, last int, opts ...PoolOption) (*LazyReusePool, error) {
 	if first > last {
 		return nil, fmt.Errorf("make sure first(%d) <= last(%d)", first, last)
 	}
-	head := &segment{first, last, nil}
-	return &LazyReusePool{
+	head := &segment{first: first, last: last}
+	p := &LazyReusePool{
 		head:   head,
 		first:  first,
 		last:   last,
 		remain: last - first + 1,
-	}, nil
+		clock:  clock.Real{},
+		leases: make(map[int]*lease),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.tracker != nil {
+		p.tracker.SetNow(p.clock.Now)
+	}
+	return p, nil
 }
 
+// Allocate takes the first value of the head segment.
+// If the pool has a journal, the allocation fails when it cannot be recorded.
 func (p *LazyReusePool) Allocate() (res int, ok bool) {
+	res, err := p.allocateValue()
+	return res, err == nil
+}
+
+// allocateValue is Allocate returning errPoolEmpty, or an error wrapping ErrJournal
+func (p *LazyReusePool) allocateValue() (res int, err error) {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
+	return p.tryAllocate()
+}
 
+func (p *LazyReusePool) tryAllocate() (res int, err error) {
+	p.releaseQuarantined()
 	if p.head == nil {
-		return 0, false
+		return 0, errPoolEmpty
+	}
+	if p.journal != nil {
+		if err = p.journal.Allocated(p.head.first); err != nil {
+			return 0, journalError(err)
+		}
 	}
+	res = p.allocate()
+	p.track(res)
+	return res, nil
+}
+
+func (p *LazyReusePool) allocate() (res int) {
 	res = p.head.first
 	p.head.first++
 	if p.head.first > p.head.last {
-		p.head = p.head.next
+		p.popHead()
 	}
 	p.remain--
-	return res, true
+	return res
 }
 
+// popHead replaces the head with the first segment after it
+func (p *LazyReusePool) popHead() {
+	p.head = p.head.next
+	if p.head != nil {
+		p.index.remove(p.head.first)
+		p.order.remove(p.head)
+	}
+}
+
+// linkAfter inserts seg into the list right after prev, which is the head or a segment after it
+func (p *LazyReusePool) linkAfter(prev, seg *segment) {
+	rank := 0
+	if prev != p.head {
+		rank = p.order.rank(prev) + 1
+	}
+	seg.next = prev.next
+	prev.next = seg
+	p.index.insert(seg)
+	p.order.insert(rank, seg)
+}
+
+// unlink removes seg from the segments after the head
+func (p *LazyReusePool) unlink(seg *segment) {
+	prev := p.order.prev(seg)
+	if prev == nil {
+		prev = p.head
+	}
+	prev.next = seg.next
+	p.index.remove(seg.first)
+	p.order.remove(seg)
+}
+
+// setLast changes the last value of seg, which is the head or a segment after it
+func (p *LazyReusePool) setLast(seg *segment, last int) {
+	seg.last = last
+	if seg != p.head {
+		p.order.update(seg)
+	}
+}
+
+// Use takes value out of the pool, it returns false if value is not free.
+// If the pool has a journal, Use fails when it cannot be recorded.
 func (p *LazyReusePool) Use(value int) bool {
+	return p.useValue(value) == nil
+}
+
+// useValue is Use returning errNotFree, or an error wrapping ErrJournal
+func (p *LazyReusePool) useValue(value int) error {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
+	return p.tryUse(value)
+}
+
+func (p *LazyReusePool) tryUse(value int) error {
+	p.releaseQuarantined()
+	if p.journal != nil {
+		if !p.isFree(value) {
+			return errNotFree
+		}
+		if err := p.journal.Allocated(value); err != nil {
+			return journalError(err)
+		}
+	}
+	if !p.use(value) {
+		return errNotFree
+	}
+	p.track(value)
+	return nil
+}
 
+func (p *LazyReusePool) use(value int) bool {
 	if p.head == nil {
 		return false
 	}
-	var prev *segment
-	for cur := p.head; cur != nil; cur = cur.next {
-		switch cur.relativePosisionOf(value) {
-		case withinThisSegment:
-			if cur.first == cur.last {
-				if prev == nil {
-					p.head = cur.next
-				} else {
-					prev.next = cur.next
-				}
-			} else {
-				cur.split(value)
-			}
-			p.remain--
-			return true
+
+	if head := p.head; head.relativePosisionOf(value) == withinThisSegment {
+		switch {
+		case head.first == head.last:
+			p.popHead()
+		case value == head.first:
+			head.first++
+		case value == head.last:
+			head.last--
+		default:
+			// split, the rest of the head is allocated next
+			p.linkAfter(head, &segment{first: value + 1, last: head.last})
+			head.last = value - 1
 		}
-		prev = cur
+		p.remain--
+		return true
+	}
+
+	cur := p.index.floor(value)
+	if cur == nil || cur.last < value {
+		return false
+	}
+	switch {
+	case cur.first == cur.last:
+		p.unlink(cur)
+	case value == cur.first:
+		cur.first++
+	case value == cur.last:
+		p.setLast(cur, cur.last-1)
+	default:
+		p.linkAfter(cur, &segment{first: value + 1, last: cur.last})
+		p.setLast(cur, value-1)
 	}
-	return false
+	p.remain--
+	return true
 }
 
+// Free returns value to the pool, it returns false if value is out of the pool or already free.
+// If the pool has a journal, Free fails when it cannot be recorded.
+// If the pool has a quarantine (see WithQuarantine), value is not reusable until the quarantine ends.
+// The lease of value is cancelled.
 func (p *LazyReusePool) Free(value int) bool {
+	return p.freeValue(value) == nil
+}
+
+// freeValue is Free returning errNotAllocated, or an error wrapping ErrJournal
+func (p *LazyReusePool) freeValue(value int) error {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
+	return p.tryFree(value)
+}
 
+func (p *LazyReusePool) tryFree(value int) error {
+	p.releaseQuarantined()
+	if p.journal != nil || p.quarantine > 0 {
+		if value < p.first || p.last < value || p.isFree(value) || p.isQuarantined(value) {
+			return errNotAllocated
+		}
+	}
+	if p.journal != nil {
+		if err := p.journal.Released(value); err != nil {
+			return journalError(err)
+		}
+	}
+	p.cancelLease(value)
+	if p.quarantine > 0 {
+		p.quarantineValue(value)
+		p.untrack(value)
+		return nil
+	}
+	if !p.free(value) {
+		return errNotAllocated
+	}
+	p.untrack(value)
+	return nil
+}
+
+func (p *LazyReusePool) free(value int) bool {
 	// Ensure the value is within this pool
 	if value < p.first || p.last < value {
 		return false
@@ -107,110 +297,204 @@ func (p *LazyReusePool) Free(value int) bool {
 	// for lazy reuse, excepted in the case of the value is
 	// adjacent to the back of the head.
 
-	switch p.head.relativePosisionOf(value) {
-	case withinThisSegment:
+	if p.isFree(value) {
 		// duplecated free
 		return false
-	case adjacentToTheBack:
+	}
+	if p.head.relativePosisionOf(value) == adjacentToTheBack {
 		// only in this case, returned into the head segment
-		p.head.extendLast()
+		p.head.last++
+		if next := p.head.next; next != nil && next.first == value+1 {
+			// concatenate
+			p.head.last = next.last
+			p.unlink(next)
+		}
 		p.remain++
 		return true
 	}
 
-	// When there is only head segment
-	if p.head.next == nil {
-		// add a new segment
-		p.head.next = newSingleSegment(value)
-		p.remain++
-		return true
+	// the value goes to the first segment of the list it is before or adjacent to
+	cur := p.order.reaching(value - 1)
+	switch {
+	case cur == nil:
+		// append a segment
+		tail := p.order.tail()
+		if tail == nil {
+			tail = p.head
+		}
+		p.linkAfter(tail, newSingleSegment(value))
+	case value < cur.first-1:
+		// insert a segment
+		prev := p.order.prev(cur)
+		if prev == nil {
+			prev = p.head
+		}
+		p.linkAfter(prev, newSingleSegment(value))
+	case value == cur.first-1:
+		// extendFirst, the order of segments is kept
+		cur.first = value
+	default:
+		// adjacent to the back
+		p.setLast(cur, value)
+		if next := cur.next; next != nil && next.first == value+1 {
+			// concatenate
+			p.setLast(cur, next.last)
+			p.unlink(next)
+		}
+	}
+	p.remain++
+	return true
+}
+
+// releaseRange returns the values of r, which must be in the pool and not free, like Free of each value
+// in ascending order, in O(log n) of the number of segments
+func (p *LazyReusePool) releaseRange(r Range) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.freeRange(r.First, r.Last)
+}
+
+// freeRange returns [first, last] to the pool, see releaseRange
+func (p *LazyReusePool) freeRange(first, last int) {
+	p.reserved = subtractRanges(p.reserved, []Range{{First: first, Last: last}})
+	p.remain += last - first + 1
+	if p.head == nil {
+		p.head = &segment{first: first, last: last}
+		return
 	}
 
-	prev := p.head
-	cur := p.head.next
-	for ; cur != nil; prev, cur = cur, cur.next {
-		pos := cur.relativePosisionOf(value)
-		switch pos {
-		case before:
-			// insert a segment
-			temp := newSingleSegment(value)
-			prev.next = temp
-			temp.next = cur
-			goto success
-		case adjacentToTheFront:
-			// extendFirst
-			cur.first = value
-			goto success
-		case withinThisSegment:
-			// duplecated free
-			return false
-		case adjacentToTheBack:
-			cur.extendLast()
-			goto success
-		case after:
-			if cur.next == nil {
-				cur.next = newSingleSegment(value)
-				goto success
-			}
-			// to next loop
+	// the head is free, so it cannot end within the range
+	if p.head.last+1 == first {
+		p.head.last = last
+		if next := p.head.next; next != nil && next.first == last+1 {
+			// concatenate
+			p.head.last = next.last
+			p.unlink(next)
 		}
+		return
 	}
 
-success:
-	p.remain++
-	return true
+	// like free of first, then of the following values into the same segment
+	cur := p.order.reaching(first - 1)
+	switch {
+	case cur == nil:
+		tail := p.order.tail()
+		if tail == nil {
+			tail = p.head
+		}
+		p.linkAfter(tail, &segment{first: first, last: last})
+	case last+1 == cur.first:
+		cur.first = first
+	case first < cur.first:
+		prev := p.order.prev(cur)
+		if prev == nil {
+			prev = p.head
+		}
+		p.linkAfter(prev, &segment{first: first, last: last})
+	default:
+		p.setLast(cur, last)
+		if next := cur.next; next != nil && next.first == last+1 {
+			// concatenate
+			p.setLast(cur, next.last)
+			p.unlink(next)
+		}
+	}
 }
 
 func (p *LazyReusePool) Reserve(first, last int) error {
-	if !p.Contains(first, last) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	// checked under the lock, the range may be changed by Resize
+	if !p.contains(first, last) {
 		return fmt.Errorf("reserve range should in [%d, %d]", p.first, p.last)
 	}
+	p.reserve(first, last)
+	return nil
+}
 
-	for cur, prev := p.head, (*segment)(nil); cur != nil; cur = cur.next {
+// reserve removes [first, last] from the pool, the caller must hold p.mtx
+func (p *LazyReusePool) reserve(first, last int) {
+	p.reserved = Union(p.reserved, []Range{{First: first, Last: last}})
+	// quarantined values in the range stay out of the pool
+	p.quarantined.remove(first, last)
+
+	// reserve the head, the next segment becomes the head if the whole head is reserved
+	for p.head != nil {
+		head := p.head
+		if head.last < first || last < head.first {
+			break
+		}
+		if first <= head.first && head.last <= last {
+			p.remain -= head.last - head.first + 1
+			p.popHead()
+			continue
+		}
 		switch {
-		case cur.first >= first && cur.first <= last && cur.last > last:
+		case first <= head.first:
+			p.remain -= last - head.first + 1
+			head.first = last + 1
+		case head.last <= last:
+			p.remain -= head.last - first + 1
+			head.last = first - 1
+		default:
+			p.remain -= last - first + 1
+			p.linkAfter(head, &segment{first: last + 1, last: head.last})
+			head.last = first - 1
+		}
+		break
+	}
+	if p.head == nil {
+		return
+	}
+
+	// reserve the segments after the head overlapping the range, in place in the list
+	cur := p.index.floor(first)
+	if cur == nil || cur.last < first {
+		cur = p.index.higher(first)
+	}
+	for cur != nil && cur.first <= last {
+		next := p.index.higher(cur.first)
+		switch {
+		case first <= cur.first && cur.last <= last:
+			p.remain -= cur.last - cur.first + 1
+			p.unlink(cur)
+		case first <= cur.first:
 			p.remain -= last - cur.first + 1
 			cur.first = last + 1
-		case cur.first < first && cur.last >= first && cur.last <= last:
+		case cur.last <= last:
 			p.remain -= cur.last - first + 1
-			cur.last = first - 1
-		case cur.first < first && cur.last > last:
-			cur.next = &segment{
-				first: last + 1,
-				last:  cur.last,
-				next:  cur.next,
-			}
-
-			cur.last = first - 1
+			p.setLast(cur, first-1)
+		default:
 			p.remain -= last - first + 1
-
-		// this segment in reserve range
-		case cur.first >= first && cur.last <= last:
-			p.remain -= cur.last - cur.first + 1
-			if prev != nil {
-				prev.next = cur.next
-			} else {
-				p.head = cur.next
-			}
-			// do not update prev
-			continue
+			p.linkAfter(cur, &segment{first: last + 1, last: cur.last})
+			p.setLast(cur, first-1)
+			return
 		}
-
-		prev = cur
+		cur = next
 	}
-
-	return nil
 }
 
 func (p *LazyReusePool) Contains(first, last int) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.contains(first, last)
+}
+
+// contains is Contains, the caller must hold p.mtx
+func (p *LazyReusePool) contains(first, last int) bool {
 	return first <= last && p.first <= first && p.last >= last
 }
 
 func (p *LazyReusePool) Min() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
 	return p.first
 }
 
 func (p *LazyReusePool) Max() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
 	return p.last
 }
 
@@ -219,11 +503,25 @@ func (p *LazyReusePool) Remain() int {
 }
 
 func (p *LazyReusePool) Total() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
 	return p.last - p.first + 1
 }
 
+// isFree returns true if value is in a segment
+func (p *LazyReusePool) isFree(value int) bool {
+	if p.head == nil {
+		return false
+	}
+	if p.head.first <= value && value <= p.head.last {
+		return true
+	}
+	cur := p.index.floor(value)
+	return cur != nil && value <= cur.last
+}
+
 func newSingleSegment(num int) *segment {
-	return &segment{num, num, nil}
+	return &segment{first: num, last: num}
 }
 
 func (s *segment) relativePosisionOf(value int) relativePos {
@@ -241,40 +539,6 @@ func (s *segment) relativePosisionOf(value int) relativePos {
 	}
 }
 
-func (s *segment) split(use int) bool {
-	if use < s.first || use > s.last {
-		return false
-	}
-
-	switch use {
-	case s.first:
-		s.first += 1
-	case s.last:
-		s.last -= 1
-	default:
-		next := &segment{
-			first: use + 1,
-			last:  s.last,
-			next:  s.next,
-		}
-
-		s.next = next
-		s.last = use - 1
-	}
-
-	return true
-}
-
-func (s *segment) extendLast() *segment {
-	s.last++
-	if s.next != nil && s.last+1 == s.next.first {
-		// concatenate
-		s.last = s.next.last
-		s.next = s.next.next
-	}
-	return s
-}
-
 func (p1 *LazyReusePool) IsJoint(p2 *LazyReusePool) bool {
 	if p2.last < p1.first || p1.last < p2.first {
 		return false
@@ -2,6 +2,8 @@ package ippool
 
 import (
 	"fmt"
+	"math"
+	"math/rand"
 	"os"
 	"runtime/debug"
 	"sort"
@@ -261,6 +263,35 @@ func TestLazyReusePool_ManySegment(t *testing.T) {
 	assert.Equal(t, 100, p.head.next.next.last)
 }
 
+func TestLazyReusePool_FreeAdjacentToHead(t *testing.T) {
+	p, err := NewLazyReusePool(1, 10)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, ok := p.Allocate()
+		require.True(t, ok)
+	}
+
+	assert.True(t, p.Free(5)) // -> 5-5
+	assert.True(t, p.Free(7)) // -> 5-5 -> 7-7
+	assert.True(t, p.Free(1)) // -> 5-5 -> 1-1 -> 7-7
+
+	// the head is concatenated only with the segment next to it, 7-7 is still reused after 1-1
+	assert.True(t, p.Free(6)) // -> 5-6 -> 1-1 -> 7-7
+	assert.Equal(t, [][]int{{5, 6}, {1, 1}, {7, 7}}, p.Dump())
+	assert.False(t, p.Free(7))
+	assert.Equal(t, 4, p.Remain())
+
+	var allocated []int
+	for {
+		value, ok := p.Allocate()
+		if !ok {
+			break
+		}
+		allocated = append(allocated, value)
+	}
+	assert.Equal(t, []int{5, 6, 1, 7}, allocated)
+}
+
 func TestLazyReusePool_ReserveSection(t *testing.T) {
 	p, err := NewLazyReusePool(1, 100)
 	require.NoError(t, err)
@@ -459,3 +490,386 @@ func TestLazyReusePool_ManyGoroutine(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, 900-numOfThreads-1, p.Remain())
 }
+
+func TestLazyReusePool_RandomChurn(t *testing.T) {
+	const first, last = 1, 2000
+	p, err := NewLazyReusePool(first, last)
+	require.NoError(t, err)
+	rnd := rand.New(rand.NewSource(1))
+
+	// free values of the reference model
+	free := make(map[int]bool)
+	for v := first; v <= last; v++ {
+		free[v] = true
+	}
+
+	for i := 0; i < 5000; i++ {
+		value := first + rnd.Intn(last-first+1)
+		switch op := rnd.Intn(10); {
+		case op < 4:
+			a, ok := p.Allocate()
+			require.Equal(t, len(free) > 0, ok)
+			if ok {
+				require.True(t, free[a], "allocated %d is not free", a)
+				delete(free, a)
+			}
+		case op < 6:
+			require.Equal(t, free[value], p.Use(value), "Use(%d)", value)
+			delete(free, value)
+		case op < 9:
+			require.Equal(t, !free[value], p.Free(value), "Free(%d)", value)
+			free[value] = true
+		default:
+			end := value + rnd.Intn(10)
+			if end > last {
+				end = last
+			}
+			require.NoError(t, p.Reserve(value, end))
+			for v := value; v <= end; v++ {
+				delete(free, v)
+			}
+		}
+
+		require.Equal(t, len(free), p.Remain())
+		checkSegments(t, p, free)
+	}
+}
+
+// checkSegments verifies the segments of p hold the free values
+// and the segments after the head are ascending, separated and indexed
+func checkSegments(t *testing.T, p *LazyReusePool, free map[int]bool) {
+	count := 0
+	for _, seg := range p.Dump() {
+		for v := seg[0]; v <= seg[1]; v++ {
+			require.True(t, free[v], "%d in segment %v is not free", v, seg)
+			count++
+		}
+	}
+	require.Equal(t, len(free), count)
+
+	// the segment tree holds the segments after the head by value, the order tree in list order
+	var indexed []*segment
+	var walk func(node *segmentNode)
+	walk = func(node *segmentNode) {
+		if node != nil {
+			walk(node.left)
+			indexed = append(indexed, node.seg)
+			walk(node.right)
+		}
+	}
+	walk(p.index.root)
+	var ordered []*segment
+	var walkOrder func(node *orderNode) int
+	walkOrder = func(node *orderNode) int {
+		if node == nil {
+			return math.MinInt
+		}
+		maxLast := max(walkOrder(node.left), node.seg.last)
+		ordered = append(ordered, node.seg)
+		maxLast = max(maxLast, walkOrder(node.right))
+		require.Equal(t, maxLast, node.maxLast)
+		return maxLast
+	}
+	walkOrder(p.order.root)
+
+	var linked []*segment
+	if p.head != nil {
+		for cur := p.head.next; cur != nil; cur = cur.next {
+			linked = append(linked, cur)
+		}
+	}
+	require.Equal(t, linked, ordered)
+	require.Len(t, p.order.nodes, len(linked))
+	sort.Slice(linked, func(i, j int) bool { return linked[i].first < linked[j].first })
+	require.Equal(t, linked, indexed)
+}
+
+// listPool is the LazyReusePool before the segments were indexed, a plain list walked in order.
+// It is the reference of the order of Dump and of the allocations.
+type listPool struct {
+	head *listSegment
+}
+
+type listSegment struct {
+	first, last int
+	next        *listSegment
+}
+
+func (p *listPool) allocate() (int, bool) {
+	if p.head == nil {
+		return 0, false
+	}
+	res := p.head.first
+	p.head.first++
+	if p.head.first > p.head.last {
+		p.head = p.head.next
+	}
+	return res, true
+}
+
+func (p *listPool) use(value int) bool {
+	var prev *listSegment
+	for cur := p.head; cur != nil; prev, cur = cur, cur.next {
+		if value < cur.first || cur.last < value {
+			continue
+		}
+		switch {
+		case cur.first == cur.last:
+			if prev == nil {
+				p.head = cur.next
+			} else {
+				prev.next = cur.next
+			}
+		case value == cur.first:
+			cur.first++
+		case value == cur.last:
+			cur.last--
+		default:
+			cur.next = &listSegment{first: value + 1, last: cur.last, next: cur.next}
+			cur.last = value - 1
+		}
+		return true
+	}
+	return false
+}
+
+// free returns value, which must not be free
+func (p *listPool) free(value int) {
+	if p.head == nil {
+		p.head = &listSegment{first: value, last: value}
+		return
+	}
+	extendLast := func(s *listSegment) {
+		s.last++
+		if s.next != nil && s.last+1 == s.next.first {
+			s.last = s.next.last
+			s.next = s.next.next
+		}
+	}
+	if value == p.head.last+1 {
+		extendLast(p.head)
+		return
+	}
+	prev := p.head
+	for cur := p.head.next; cur != nil; prev, cur = cur, cur.next {
+		switch {
+		case value < cur.first-1:
+			prev.next = &listSegment{first: value, last: value, next: cur}
+			return
+		case value == cur.first-1:
+			cur.first = value
+			return
+		case value == cur.last+1:
+			extendLast(cur)
+			return
+		}
+	}
+	prev.next = &listSegment{first: value, last: value}
+}
+
+func (p *listPool) reserve(first, last int) {
+	for cur, prev := p.head, (*listSegment)(nil); cur != nil; cur = cur.next {
+		switch {
+		case cur.first >= first && cur.first <= last && cur.last > last:
+			cur.first = last + 1
+		case cur.first < first && cur.last >= first && cur.last <= last:
+			cur.last = first - 1
+		case cur.first < first && cur.last > last:
+			cur.next = &listSegment{first: last + 1, last: cur.last, next: cur.next}
+			cur.last = first - 1
+		case cur.first >= first && cur.last <= last:
+			if prev != nil {
+				prev.next = cur.next
+			} else {
+				p.head = cur.next
+			}
+			continue
+		}
+		prev = cur
+	}
+}
+
+func (p *listPool) dump() [][]int {
+	var dumped [][]int
+	for cur := p.head; cur != nil; cur = cur.next {
+		dumped = append(dumped, []int{cur.first, cur.last})
+	}
+	return dumped
+}
+
+func TestLazyReusePool_SplitRemainderOrder(t *testing.T) {
+	for name, split := range map[string]func(p *LazyReusePool){
+		"Use":     func(p *LazyReusePool) { require.True(t, p.Use(6)) },
+		"Reserve": func(p *LazyReusePool) { require.NoError(t, p.Reserve(6, 6)) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, err := NewLazyReusePool(1, 10)
+			require.NoError(t, err)
+			for i := 0; i < 3; i++ {
+				_, ok := p.Allocate()
+				require.True(t, ok)
+			}
+			require.True(t, p.Free(1))
+			split(p)
+			require.Equal(t, [][]int{{4, 5}, {7, 10}, {1, 1}}, p.Dump())
+
+			var allocated []int
+			for a, ok := p.Allocate(); ok; a, ok = p.Allocate() {
+				allocated = append(allocated, a)
+			}
+			require.Equal(t, []int{4, 5, 7, 8, 9, 10, 1}, allocated)
+		})
+	}
+}
+
+func TestLazyReusePool_ListOrder(t *testing.T) {
+	const first, last = 1, 300
+	rnd := rand.New(rand.NewSource(3))
+	for round := 0; round < 20; round++ {
+		p, err := NewLazyReusePool(first, last)
+		require.NoError(t, err)
+		ref := &listPool{head: &listSegment{first: first, last: last}}
+		var used []int
+
+		for i := 0; i < 2000; i++ {
+			value := first + rnd.Intn(last-first+1)
+			switch op := rnd.Intn(10); {
+			case op < 4:
+				a, ok := p.Allocate()
+				refA, refOk := ref.allocate()
+				require.Equal(t, refOk, ok)
+				require.Equal(t, refA, a)
+				if ok {
+					used = append(used, a)
+				}
+			case op < 6:
+				ok := p.Use(value)
+				require.Equal(t, ref.use(value), ok, "Use(%d)", value)
+				if ok {
+					used = append(used, value)
+				}
+			case op < 9:
+				if len(used) == 0 {
+					continue
+				}
+				index := rnd.Intn(len(used))
+				value = used[index]
+				used[index] = used[len(used)-1]
+				used = used[:len(used)-1]
+				require.True(t, p.Free(value))
+				ref.free(value)
+			default:
+				end := min(value+rnd.Intn(10), last)
+				require.NoError(t, p.Reserve(value, end))
+				ref.reserve(value, end)
+			}
+			require.Equal(t, ref.dump(), p.Dump(), "round %d operation %d", round, i)
+		}
+	}
+}
+
+func TestLazyReusePool_ReleaseRange(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		// p1 and p2 are driven by the same operations
+		p1, err := NewLazyReusePool(0, 99)
+		require.NoError(t, err)
+		p2, err := NewLazyReusePool(0, 99)
+		require.NoError(t, err)
+		for i := 0; i < 300; i++ {
+			value := rnd.Intn(100)
+			if rnd.Intn(2) == 0 {
+				require.Equal(t, p1.Use(value), p2.Use(value))
+			} else {
+				require.Equal(t, p1.Free(value), p2.Free(value))
+			}
+		}
+
+		// a run of used values
+		first := rnd.Intn(100)
+		for first < 100 && p1.isFree(first) {
+			first++
+		}
+		if first == 100 {
+			continue
+		}
+		last := first
+		for last < 99 && !p1.isFree(last+1) && rnd.Intn(8) != 0 {
+			last++
+		}
+
+		p1.releaseRange(Range{First: first, Last: last})
+		for value := first; value <= last; value++ {
+			require.True(t, p2.Free(value))
+		}
+		require.Equal(t, p2.Dump(), p1.Dump(), "release %d-%d", first, last)
+		require.Equal(t, p2.Remain(), p1.Remain())
+		free := make(map[int]bool)
+		for value := 0; value < 100; value++ {
+			if p2.isFree(value) {
+				free[value] = true
+			}
+		}
+		checkSegments(t, p1, free)
+	}
+}
+
+// newFragmentedPool makes a pool of size values with every other value used
+func newFragmentedPool(b *testing.B, size int) (*LazyReusePool, []int) {
+	p, err := NewLazyReusePool(0, size-1)
+	require.NoError(b, err)
+	used := make([]int, 0, size)
+	for i := 0; i < size; i++ {
+		a, ok := p.Allocate()
+		require.True(b, ok)
+		used = append(used, a)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	rnd.Shuffle(len(used), func(i, j int) { used[i], used[j] = used[j], used[i] })
+	for _, v := range used[size/2:] {
+		require.True(b, p.Free(v))
+	}
+	return p, used[:size/2]
+}
+
+func BenchmarkLazyReusePool_RandomChurn(b *testing.B) {
+	p, used := newFragmentedPool(b, 1000000)
+	rnd := rand.New(rand.NewSource(2))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// free a random used value, then allocate one or use a random one
+		index := rnd.Intn(len(used))
+		if !p.Free(used[index]) {
+			b.Fatalf("Free(%d) failed", used[index])
+		}
+		if i%2 == 0 {
+			used[index], _ = p.Allocate()
+		} else {
+			for value := rnd.Intn(1000000); ; value = rnd.Intn(1000000) {
+				if p.Use(value) {
+					used[index] = value
+					break
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkLazyReusePool_Reserve(b *testing.B) {
+	p, _ := newFragmentedPool(b, 1000000)
+	rnd := rand.New(rand.NewSource(2))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		first := rnd.Intn(1000000 - 16)
+		if err := p.Reserve(first, first+15); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		for v := first; v <= first+15; v++ {
+			p.Free(v)
+		}
+		b.StartTimer()
+	}
+}
@@ -0,0 +1,90 @@
+package ippool
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/free5gc/util/leakcheck"
+)
+
+// WithTracking records the allocation time of each allocated value, and its caller stack
+// if captureStack is true, so leaked values can be found with the audit functions of Tracker.
+// Values are tracked from Allocate, Use and Restore until they are freed.
+func WithTracking(captureStack bool) PoolOption {
+	return func(p *LazyReusePool) {
+		p.tracker = leakcheck.NewTracker(captureStack, "github.com/free5gc/util/ippool.")
+	}
+}
+
+// Tracker returns the tracker of allocations, nil if the pool is made without WithTracking
+func (p *LazyReusePool) Tracker() *leakcheck.Tracker {
+	return p.tracker
+}
+
+// SetOwner tags the allocation of value with owner, e.g. the SUPI of the UE,
+// it returns false if the pool is not tracked or value is not allocated
+func (p *LazyReusePool) SetOwner(value int, owner string) bool {
+	if p.tracker == nil {
+		return false
+	}
+	return p.tracker.SetOwner(int64(value), owner)
+}
+
+func (p *LazyReusePool) track(value int) {
+	if p.tracker != nil {
+		p.tracker.Track(int64(value))
+	}
+}
+
+func (p *LazyReusePool) untrack(value int) {
+	if p.tracker != nil {
+		p.tracker.Untrack(int64(value))
+	}
+}
+
+// IPAllocation is a tracked allocation of an IPPool with its address
+type IPAllocation struct {
+	IP net.IP
+	leakcheck.Allocation
+}
+
+// SetOwner tags the allocation of ip with owner, see LazyReusePool.SetOwner
+func (p *IPPool) SetOwner(ip net.IP, owner string) error {
+	value, err := p.valueOf(ip)
+	if err != nil {
+		return err
+	}
+	if !p.Pool.SetOwner(value, owner) {
+		return errors.Errorf("IP[%s] is not tracked in Pool[%+v]", ip, p.Subnet())
+	}
+	return nil
+}
+
+// AllocationsOlderThan returns the tracked allocations made more than age ago
+func (p *IPPool) AllocationsOlderThan(age time.Duration) []IPAllocation {
+	if p.Pool.tracker == nil {
+		return nil
+	}
+	return p.ipAllocations(p.Pool.tracker.OlderThan(age))
+}
+
+// OrphanAllocations returns the tracked allocations whose owner is not alive
+func (p *IPPool) OrphanAllocations(alive func(owner string) bool) []IPAllocation {
+	if p.Pool.tracker == nil {
+		return nil
+	}
+	return p.ipAllocations(p.Pool.tracker.Orphans(alive))
+}
+
+func (p *IPPool) ipAllocations(allocations []leakcheck.Allocation) []IPAllocation {
+	ipAllocations := make([]IPAllocation, 0, len(allocations))
+	for _, allocation := range allocations {
+		ipAllocations = append(ipAllocations, IPAllocation{
+			IP:         p.ipOf(int(allocation.Value)),
+			Allocation: allocation,
+		})
+	}
+	return ipAllocations
+}
@@ -0,0 +1,46 @@
+package ippool
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPPool_Tracking(t *testing.T) {
+	clock := newFakeClock()
+	pool, err := NewIPPool("10.10.0.0/24", WithClock(clock), WithTracking(true))
+	require.NoError(t, err)
+
+	ip1, err := pool.Allocate(nil)
+	require.NoError(t, err)
+	require.NoError(t, pool.SetOwner(ip1, "imsi-208930000000001"))
+	clock.Advance(time.Hour)
+	ip2, err := pool.Allocate(net.ParseIP("10.10.0.100"))
+	require.NoError(t, err)
+	require.NoError(t, pool.SetOwner(ip2, "imsi-208930000000002"))
+	ip3, err := pool.Allocate(nil)
+	require.NoError(t, err)
+	require.NoError(t, pool.Release(ip3))
+	require.Error(t, pool.SetOwner(ip3, "imsi-208930000000003"))
+
+	old := pool.AllocationsOlderThan(30 * time.Minute)
+	require.Len(t, old, 1)
+	assert.Equal(t, ip1, old[0].IP)
+	assert.Equal(t, "imsi-208930000000001", old[0].Owner)
+	// the top frames of package ippool, including this test, are omitted
+	assert.True(t, strings.HasPrefix(old[0].Stack, "testing.tRunner"), old[0].Stack)
+
+	orphans := pool.OrphanAllocations(func(owner string) bool {
+		return owner == "imsi-208930000000001"
+	})
+	require.Len(t, orphans, 1)
+	assert.Equal(t, ip2, orphans[0].IP)
+
+	untracked, err := NewIPPool("10.10.0.0/24")
+	require.NoError(t, err)
+	assert.Nil(t, untracked.AllocationsOlderThan(0))
+}
@@ -0,0 +1,268 @@
+package ippool
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/free5gc/util/internal/clock"
+)
+
+// Clock provides the time of quarantines and leases, it can be replaced in tests, see WithClock
+type Clock = clock.Clock
+
+// Timer is a timer started by Clock.AfterFunc
+type Timer = clock.Timer
+
+// WithClock replaces the clock of the pool, the default is the system clock
+func WithClock(clock Clock) PoolOption {
+	return func(p *LazyReusePool) {
+		p.clock = clock
+	}
+}
+
+// WithQuarantine holds freed values for period before they can be allocated again,
+// so e.g. a just-released UE IP is not handed to another UE while packets are in flight
+// (see idgenerator.WithQuarantine for TEIDs).
+// Quarantined values are counted neither by Remain nor as used, and they are not journaled,
+// so the quarantine does not survive a restart.
+func WithQuarantine(period time.Duration) PoolOption {
+	return func(p *LazyReusePool) {
+		p.quarantine = period
+	}
+}
+
+type quarantineEntry struct {
+	value int
+	until time.Time
+}
+
+// quarantineQueue stores quarantined values in the order of their release,
+// which is also the order their quarantines end
+type quarantineQueue struct {
+	entries []quarantineEntry
+	// values maps each quarantined value to the end of its quarantine
+	values map[int]time.Time
+}
+
+func (q *quarantineQueue) push(value int, until time.Time) {
+	if q.values == nil {
+		q.values = make(map[int]time.Time)
+	}
+	q.entries = append(q.entries, quarantineEntry{value: value, until: until})
+	q.values[value] = until
+}
+
+// expire removes the values whose quarantine ended at now and calls release with each of them
+func (q *quarantineQueue) expire(now time.Time, release func(value int)) {
+	i := 0
+	for ; i < len(q.entries); i++ {
+		entry := q.entries[i]
+		if until, ok := q.values[entry.value]; !ok || !until.Equal(entry.until) {
+			// removed by remove
+			continue
+		}
+		if entry.until.After(now) {
+			break
+		}
+		delete(q.values, entry.value)
+		release(entry.value)
+	}
+	q.entries = q.entries[i:]
+}
+
+// remove drops the quarantined values in [first, last]
+func (q *quarantineQueue) remove(first, last int) {
+	for value := range q.values {
+		if first <= value && value <= last {
+			delete(q.values, value)
+		}
+	}
+}
+
+func (p *LazyReusePool) quarantineValue(value int) {
+	p.quarantined.push(value, p.clock.Now().Add(p.quarantine))
+}
+
+func (p *LazyReusePool) isQuarantined(value int) bool {
+	_, ok := p.quarantined.values[value]
+	return ok
+}
+
+// releaseQuarantined returns the values whose quarantine ended to the pool
+func (p *LazyReusePool) releaseQuarantined() {
+	if len(p.quarantined.entries) == 0 {
+		return
+	}
+	p.quarantined.expire(p.clock.Now(), func(value int) {
+		p.free(value)
+	})
+}
+
+// Quarantined returns the number of values in quarantine
+func (p *LazyReusePool) Quarantined() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.releaseQuarantined()
+	return len(p.quarantined.values)
+}
+
+type lease struct {
+	ttl   time.Duration
+	timer Timer
+}
+
+// OnLeaseExpired sets the function called with the value of each expired lease,
+// after the value is freed. It is called from the timer goroutine without the lock of the pool.
+func (p *LazyReusePool) OnLeaseExpired(f func(value int)) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.leaseExpiry = f
+}
+
+// onLeaseFreed sets the function called after each value freed by an expired lease, besides the one of
+// OnLeaseExpired, e.g. to report the usage of the pool
+func (p *LazyReusePool) onLeaseFreed(f func()) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.leaseFreed = f
+}
+
+// AllocateLease is Allocate with a lease: the value is freed after ttl unless it is renewed, see Renew
+func (p *LazyReusePool) AllocateLease(ttl time.Duration) (res int, ok bool) {
+	res, err := p.allocateLease(ttl)
+	return res, err == nil
+}
+
+// allocateLease is AllocateLease returning the error of allocateValue
+func (p *LazyReusePool) allocateLease(ttl time.Duration) (res int, err error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if res, err = p.tryAllocate(); err == nil {
+		p.startLease(res, ttl)
+	}
+	return res, err
+}
+
+// UseLease is Use with a lease, see AllocateLease
+func (p *LazyReusePool) UseLease(value int, ttl time.Duration) bool {
+	return p.useLease(value, ttl) == nil
+}
+
+// useLease is UseLease returning the error of useValue
+func (p *LazyReusePool) useLease(value int, ttl time.Duration) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if err := p.tryUse(value); err != nil {
+		return err
+	}
+	p.startLease(value, ttl)
+	return nil
+}
+
+// Renew restarts the lease of value with ttl, or leases value if it is allocated without a lease,
+// e.g. after Restore. It returns false if value is not allocated.
+func (p *LazyReusePool) Renew(value int, ttl time.Duration) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.releaseQuarantined()
+	if value < p.first || p.last < value || p.isFree(value) || p.isQuarantined(value) {
+		return false
+	}
+	p.cancelLease(value)
+	p.startLease(value, ttl)
+	return true
+}
+
+func (p *LazyReusePool) startLease(value int, ttl time.Duration) {
+	l := &lease{ttl: ttl}
+	l.timer = p.clock.AfterFunc(ttl, func() {
+		p.expireLease(value, l)
+	})
+	p.leases[value] = l
+}
+
+func (p *LazyReusePool) cancelLease(value int) {
+	if l, ok := p.leases[value]; ok {
+		l.timer.Stop()
+		delete(p.leases, value)
+	}
+}
+
+// expireLease frees value if l is still its lease, the lease is restarted if the value cannot be freed
+func (p *LazyReusePool) expireLease(value int, l *lease) {
+	p.mtx.Lock()
+	if p.leases[value] != l {
+		// renewed or freed after the timer fired
+		p.mtx.Unlock()
+		return
+	}
+	delete(p.leases, value)
+	freed := p.tryFree(value) == nil
+	if !freed {
+		// the journal failed, retry later
+		p.startLease(value, l.ttl)
+	}
+	leaseExpiry, leaseFreed := p.leaseExpiry, p.leaseFreed
+	p.mtx.Unlock()
+
+	if freed && leaseFreed != nil {
+		leaseFreed()
+	}
+	if freed && leaseExpiry != nil {
+		leaseExpiry(value)
+	}
+}
+
+// OnLeaseExpired sets the function called with the address of each expired lease, see LazyReusePool.OnLeaseExpired
+func (p *IPPool) OnLeaseExpired(f func(ip net.IP)) {
+	if f == nil {
+		p.Pool.OnLeaseExpired(nil)
+		return
+	}
+	p.Pool.OnLeaseExpired(func(value int) {
+		f(p.ipOf(value))
+	})
+}
+
+// AllocateLease is Allocate with a lease, the address is released after ttl unless it is renewed
+func (p *IPPool) AllocateLease(request net.IP, ttl time.Duration) (net.IP, error) {
+	ip, err := p.allocateLease(request, ttl)
+	p.report(err != nil)
+	return ip, err
+}
+
+func (p *IPPool) allocateLease(request net.IP, ttl time.Duration) (net.IP, error) {
+	if request != nil {
+		value, err := p.valueOf(request)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.Pool.useLease(value, ttl); err != nil {
+			return nil, p.allocationError(err, request)
+		}
+		return p.ipOf(value), nil
+	}
+
+	value, err := p.Pool.allocateLease(ttl)
+	if err != nil {
+		return nil, p.allocationError(err, nil)
+	}
+	return p.ipOf(value), nil
+}
+
+// Renew restarts the lease of ip with ttl, see LazyReusePool.Renew
+func (p *IPPool) Renew(ip net.IP, ttl time.Duration) error {
+	value, err := p.valueOf(ip)
+	if err != nil {
+		return err
+	}
+	if !p.Pool.Renew(value, ttl) {
+		return errors.Errorf("IP[%s] is not allocated in Pool[%+v]", ip, p.Subnet())
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package ippool
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/util/internal/clock"
+)
+
+// newFakeClock makes a clock whose timers fire synchronously when Advance is called
+func newFakeClock() *clock.Fake {
+	return clock.NewFake()
+}
+
+func TestLazyReusePool_Quarantine(t *testing.T) {
+	clock := newFakeClock()
+	p, err := NewLazyReusePool(1, 3, WithClock(clock), WithQuarantine(10*time.Second))
+	require.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		a, ok := p.Allocate()
+		require.True(t, ok)
+		require.Equal(t, i, a)
+	}
+
+	require.True(t, p.Free(2))
+	// duplicated free
+	require.False(t, p.Free(2))
+	assert.Equal(t, 1, p.Quarantined())
+	assert.Equal(t, 0, p.Remain())
+	_, ok := p.Allocate()
+	assert.False(t, ok)
+	assert.False(t, p.Use(2))
+
+	clock.Advance(5 * time.Second)
+	require.True(t, p.Free(1))
+	assert.Equal(t, 2, p.Quarantined())
+
+	// quarantines end in the order of release
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, 1, p.Quarantined())
+	a, ok := p.Allocate()
+	require.True(t, ok)
+	assert.Equal(t, 2, a)
+	_, ok = p.Allocate()
+	assert.False(t, ok)
+
+	// reserved values do not come back from quarantine
+	require.NoError(t, p.Reserve(1, 1))
+	assert.Equal(t, 0, p.Quarantined())
+	clock.Advance(time.Minute)
+	_, ok = p.Allocate()
+	assert.False(t, ok)
+	assert.Equal(t, 0, p.Remain())
+}
+
+func TestLazyReusePool_Lease(t *testing.T) {
+	clock := newFakeClock()
+	p, err := NewLazyReusePool(1, 10, WithClock(clock))
+	require.NoError(t, err)
+	var expired []int
+	p.OnLeaseExpired(func(value int) {
+		expired = append(expired, value)
+	})
+
+	a, ok := p.AllocateLease(10 * time.Second)
+	require.True(t, ok)
+	require.Equal(t, 1, a)
+	require.True(t, p.UseLease(5, 10*time.Second))
+	require.False(t, p.UseLease(5, 10*time.Second))
+	b, ok := p.Allocate()
+	require.True(t, ok)
+
+	clock.Advance(8 * time.Second)
+	require.True(t, p.Renew(a, 10*time.Second))
+	// a value allocated without a lease can be leased
+	require.True(t, p.Renew(b, 5*time.Second))
+	// not allocated
+	require.False(t, p.Renew(9, time.Second))
+
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, []int{5}, expired)
+	assert.Equal(t, 8, p.Remain())
+
+	// freed values lose their lease
+	require.True(t, p.Free(a))
+	clock.Advance(time.Minute)
+	assert.Equal(t, []int{5, b}, expired)
+	assert.Equal(t, 10, p.Remain())
+}
+
+func TestIPPool_Lease(t *testing.T) {
+	clock := newFakeClock()
+	pool, err := NewIPPool("10.10.0.0/29", WithClock(clock), WithQuarantine(time.Minute))
+	require.NoError(t, err)
+	var expired []net.IP
+	pool.OnLeaseExpired(func(ip net.IP) {
+		expired = append(expired, ip)
+	})
+
+	ip, err := pool.AllocateLease(nil, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, net.ParseIP("10.10.0.1").To4(), ip)
+	_, err = pool.AllocateLease(net.ParseIP("10.10.0.1"), time.Hour)
+	require.Error(t, err)
+	require.Error(t, pool.Renew(net.ParseIP("10.10.0.2"), time.Hour))
+
+	clock.Advance(time.Hour)
+	assert.Equal(t, []net.IP{ip}, expired)
+	// the expired address is in quarantine
+	_, err = pool.Allocate(ip)
+	require.Error(t, err)
+	clock.Advance(time.Minute)
+	_, err = pool.Allocate(ip)
+	require.NoError(t, err)
+}
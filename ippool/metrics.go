@@ -0,0 +1,284 @@
+package ippool
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	METRICS_SUBSYSTEM_NAME = "ippool"
+
+	POOL_TOTAL_GAUGE_NAME = "pool_total"
+	POOL_TOTAL_GAUGE_DESC = "Number of values of the pool"
+
+	POOL_USED_GAUGE_NAME = "pool_used"
+	POOL_USED_GAUGE_DESC = "Number of values of the pool not available for allocation"
+
+	POOL_FREE_GAUGE_NAME = "pool_free"
+	POOL_FREE_GAUGE_DESC = "Number of values of the pool available for allocation"
+
+	GROUP_TOTAL_GAUGE_NAME = "group_total"
+	GROUP_TOTAL_GAUGE_DESC = "Number of values of all pools of the group"
+
+	GROUP_USED_GAUGE_NAME = "group_used"
+	GROUP_USED_GAUGE_DESC = "Number of values of all pools of the group not available for allocation"
+
+	GROUP_FREE_GAUGE_NAME = "group_free"
+	GROUP_FREE_GAUGE_DESC = "Number of values of all pools of the group available for allocation"
+
+	ALLOCATION_FAILURE_COUNTER_NAME = "allocation_failures_total"
+	ALLOCATION_FAILURE_COUNTER_DESC = "Total number of failed allocations"
+)
+
+// metric collectors label names
+const (
+	POOL_LABEL  = "pool"
+	GROUP_LABEL = "group"
+)
+
+// WatermarkLevel tells which watermark is crossed, see Metrics.SetWatermarks
+type WatermarkLevel int
+
+const (
+	// WatermarkHigh is reported when the utilization rises to the high watermark
+	WatermarkHigh WatermarkLevel = iota
+	// WatermarkLow is reported when the utilization falls back to the low watermark
+	WatermarkLow
+)
+
+func (level WatermarkLevel) String() string {
+	if level == WatermarkHigh {
+		return "High"
+	}
+	return "Low"
+}
+
+// WatermarkFunc is called with the name of the pool or group crossing a watermark
+// and its utilization, the ratio of used values in [0, 1]
+type WatermarkFunc func(name string, level WatermarkLevel, utilization float64)
+
+// metricsBinding is the Metrics reporting a pool or group, and the name of the pool or group
+type metricsBinding struct {
+	metrics *Metrics
+	name    string
+}
+
+type watermark struct {
+	high, low float64
+	f         WatermarkFunc
+	// raised is true after the high watermark is reported and until the low watermark is reported
+	raised bool
+}
+
+// Metrics collects the usage of the IPPools and PoolGroups added to it,
+// it must be registered with Collectors, e.g. as custom collectors of metrics.InitMetrics
+type Metrics struct {
+	mtx        sync.Mutex
+	pools      map[string]*IPPool
+	groups     map[string]*PoolGroup
+	watermarks map[string]*watermark
+
+	poolTotal  *prometheus.Desc
+	poolUsed   *prometheus.Desc
+	poolFree   *prometheus.Desc
+	groupTotal *prometheus.Desc
+	groupUsed  *prometheus.Desc
+	groupFree  *prometheus.Desc
+
+	allocationFailures *prometheus.CounterVec
+}
+
+// NewMetrics creates the ippool collectors
+func NewMetrics(namespace string) *Metrics {
+	desc := func(name, help, label string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, METRICS_SUBSYSTEM_NAME, name), help, []string{label}, nil)
+	}
+	return &Metrics{
+		pools:      make(map[string]*IPPool),
+		groups:     make(map[string]*PoolGroup),
+		watermarks: make(map[string]*watermark),
+		poolTotal:  desc(POOL_TOTAL_GAUGE_NAME, POOL_TOTAL_GAUGE_DESC, POOL_LABEL),
+		poolUsed:   desc(POOL_USED_GAUGE_NAME, POOL_USED_GAUGE_DESC, POOL_LABEL),
+		poolFree:   desc(POOL_FREE_GAUGE_NAME, POOL_FREE_GAUGE_DESC, POOL_LABEL),
+		groupTotal: desc(GROUP_TOTAL_GAUGE_NAME, GROUP_TOTAL_GAUGE_DESC, GROUP_LABEL),
+		groupUsed:  desc(GROUP_USED_GAUGE_NAME, GROUP_USED_GAUGE_DESC, GROUP_LABEL),
+		groupFree:  desc(GROUP_FREE_GAUGE_NAME, GROUP_FREE_GAUGE_DESC, GROUP_LABEL),
+		allocationFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: METRICS_SUBSYSTEM_NAME,
+				Name:      ALLOCATION_FAILURE_COUNTER_NAME,
+				Help:      ALLOCATION_FAILURE_COUNTER_DESC,
+			},
+			[]string{POOL_LABEL, GROUP_LABEL},
+		),
+	}
+}
+
+// Collectors returns the collectors to register
+func (m *Metrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m, m.allocationFailures}
+}
+
+// Describe implements prometheus.Collector for the usage gauges
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		m.poolTotal, m.poolUsed, m.poolFree, m.groupTotal, m.groupUsed, m.groupFree,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector, the usage gauges are read from the pools on each scrape
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	// the usage is read without m.mtx: a group reports to m with its own lock held
+	m.mtx.Lock()
+	pools := make(map[string]*IPPool, len(m.pools))
+	for name, pool := range m.pools {
+		pools[name] = pool
+	}
+	groups := make(map[string]*PoolGroup, len(m.groups))
+	for name, group := range m.groups {
+		groups[name] = group
+	}
+	m.mtx.Unlock()
+
+	collect := func(totalDesc, usedDesc, freeDesc *prometheus.Desc, name string, total, free float64) {
+		ch <- prometheus.MustNewConstMetric(totalDesc, prometheus.GaugeValue, total, name)
+		ch <- prometheus.MustNewConstMetric(usedDesc, prometheus.GaugeValue, total-free, name)
+		ch <- prometheus.MustNewConstMetric(freeDesc, prometheus.GaugeValue, free, name)
+	}
+	for name, pool := range pools {
+		collect(m.poolTotal, m.poolUsed, m.poolFree, name, float64(pool.Pool.Total()), float64(pool.Pool.Remain()))
+	}
+	for name, group := range groups {
+		total, free := group.usage()
+		collect(m.groupTotal, m.groupUsed, m.groupFree, name, total, free)
+	}
+}
+
+// AddPool reports the usage of pool labeled with name, it must be called before pool is used.
+// Names are shared by pools and groups and must be unique.
+func (m *Metrics) AddPool(name string, pool *IPPool) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if err := m.checkName(name); err != nil {
+		return err
+	}
+	m.pools[name] = pool
+	pool.metrics.Store(&metricsBinding{metrics: m, name: name})
+	// the addresses freed by expired leases are not reported by the methods of pool
+	pool.Pool.onLeaseFreed(func() {
+		pool.report(false)
+	})
+	return nil
+}
+
+// AddGroup reports the usage of group labeled with name, it must be called before group is used.
+// The member pools of group are not reported unless they are added by AddPool.
+func (m *Metrics) AddGroup(name string, group *PoolGroup) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if err := m.checkName(name); err != nil {
+		return err
+	}
+	m.groups[name] = group
+	group.metrics.Store(&metricsBinding{metrics: m, name: name})
+	return nil
+}
+
+func (m *Metrics) checkName(name string) error {
+	_, isPool := m.pools[name]
+	_, isGroup := m.groups[name]
+	if isPool || isGroup {
+		return errors.Errorf("Duplicate pool name: %s", name)
+	}
+	return nil
+}
+
+// Remove stops reporting the pool or group of name
+func (m *Metrics) Remove(name string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if pool, ok := m.pools[name]; ok {
+		pool.metrics.Store(nil)
+		pool.Pool.onLeaseFreed(nil)
+		delete(m.pools, name)
+		m.allocationFailures.DeleteLabelValues(name, "")
+	}
+	if group, ok := m.groups[name]; ok {
+		group.metrics.Store(nil)
+		delete(m.groups, name)
+		m.allocationFailures.DeleteLabelValues("", name)
+	}
+	delete(m.watermarks, name)
+}
+
+// SetWatermarks calls f with WatermarkHigh when the utilization of the pool or group of name
+// rises to high, then with WatermarkLow when it falls back to low.
+// The utilization is checked after each allocation and release through the IPPool or PoolGroup.
+func (m *Metrics) SetWatermarks(name string, high, low float64, f WatermarkFunc) error {
+	if low > high || low < 0 || high > 1 {
+		return errors.Errorf("Invalid watermarks: high %v, low %v", high, low)
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	_, isPool := m.pools[name]
+	_, isGroup := m.groups[name]
+	if !isPool && !isGroup {
+		return errors.Errorf("Unknown pool name: %s", name)
+	}
+	m.watermarks[name] = &watermark{high: high, low: low, f: f}
+	return nil
+}
+
+// report counts a failed allocation and checks the watermarks of the pool or group of name
+func (m *Metrics) report(poolName, groupName string, failed bool, total, free float64) {
+	if failed {
+		m.allocationFailures.WithLabelValues(poolName, groupName).Inc()
+	}
+
+	name := poolName + groupName
+	utilization := (total - free) / total
+
+	m.mtx.Lock()
+	wm, ok := m.watermarks[name]
+	var level WatermarkLevel
+	crossed := false
+	if ok {
+		switch {
+		case !wm.raised && utilization >= wm.high:
+			wm.raised, level, crossed = true, WatermarkHigh, true
+		case wm.raised && utilization <= wm.low:
+			wm.raised, level, crossed = false, WatermarkLow, true
+		}
+	}
+	m.mtx.Unlock()
+
+	if crossed {
+		wm.f(name, level, utilization)
+	}
+}
+
+// report reports an allocation or release of the pool to its Metrics
+func (p *IPPool) report(failed bool) {
+	if b := p.metrics.Load(); b != nil {
+		b.metrics.report(b.name, "", failed, float64(p.Pool.Total()), float64(p.Pool.Remain()))
+	}
+}
+
+// report reports an allocation or release of the group to its Metrics
+func (g *PoolGroup) report(failed bool) {
+	if b := g.metrics.Load(); b != nil {
+		total, free := g.usage()
+		b.metrics.report("", b.name, failed, total, free)
+	}
+}
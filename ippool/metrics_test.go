@@ -0,0 +1,159 @@
+package ippool
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/util/metrics/utils"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics("test")
+	reg := prometheus.NewRegistry()
+	for _, collector := range m.Collectors() {
+		require.NoError(t, reg.Register(collector))
+	}
+
+	pool, err := NewIPPool("10.10.0.0/29")
+	require.NoError(t, err)
+	group, err := NewPoolGroupFromCIDRs(FillFirst, []string{"10.20.0.0/30", "10.21.0.0/30"})
+	require.NoError(t, err)
+	require.NoError(t, m.AddPool("internet", pool))
+	require.NoError(t, m.AddGroup("ims", group))
+	require.EqualError(t, m.AddPool("ims", pool), "Duplicate pool name: ims")
+
+	var alerts []string
+	alert := func(name string, level WatermarkLevel, utilization float64) {
+		alerts = append(alerts, fmt.Sprintf("%s:%s:%.2f", name, level, utilization))
+	}
+	require.NoError(t, m.SetWatermarks("internet", 0.75, 0.5, alert))
+	require.NoError(t, m.SetWatermarks("ims", 1, 0.5, alert))
+	require.Error(t, m.SetWatermarks("internet", 0.5, 0.75, alert))
+	require.Error(t, m.SetWatermarks("unknown", 0.75, 0.5, alert))
+
+	// 2 of 8 are reserved
+	var ips []net.IP
+	for i := 0; i < 4; i++ {
+		ip, allocErr := pool.Allocate(nil)
+		require.NoError(t, allocErr)
+		ips = append(ips, ip)
+	}
+	assert.Equal(t, []string{"internet:High:0.75"}, alerts)
+	require.NoError(t, pool.Release(ips[0]))
+	assert.Equal(t, []string{"internet:High:0.75"}, alerts)
+	require.NoError(t, pool.Release(ips[1]))
+	assert.Equal(t, []string{"internet:High:0.75", "internet:Low:0.50"}, alerts)
+
+	alerts = nil
+	for i := 0; i < 4; i++ {
+		_, err = group.Allocate(nil)
+		require.NoError(t, err)
+	}
+	_, err = group.Allocate(nil)
+	require.Error(t, err)
+	assert.Equal(t, []string{"ims:High:1.00"}, alerts)
+
+	gauges := gatherValues(t, reg)
+	assert.Equal(t, 8.0, gauges["test_ippool_pool_total{pool=internet}"])
+	assert.Equal(t, 4.0, gauges["test_ippool_pool_used{pool=internet}"])
+	assert.Equal(t, 4.0, gauges["test_ippool_pool_free{pool=internet}"])
+	assert.Equal(t, 8.0, gauges["test_ippool_group_total{group=ims}"])
+	assert.Equal(t, 8.0, gauges["test_ippool_group_used{group=ims}"])
+	assert.Equal(t, 0.0, gauges["test_ippool_group_free{group=ims}"])
+
+	// exhausted members are skipped by the group, only the group allocation fails
+	value, err := utils.GetCounterVecValue("failures", m.allocationFailures, prometheus.Labels{
+		POOL_LABEL: "", GROUP_LABEL: "ims",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, value)
+
+	m.Remove("ims")
+	_, err = group.Allocate(nil)
+	require.Error(t, err)
+	_, ok := gatherValues(t, reg)["test_ippool_group_total{group=ims}"]
+	assert.False(t, ok)
+}
+
+// gatherValues returns the values of all gathered metrics keyed by name{label=value,...}
+func gatherValues(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := family.GetName() + "{"
+			for i, label := range metric.GetLabel() {
+				if i > 0 {
+					key += ","
+				}
+				key += label.GetName() + "=" + label.GetValue()
+			}
+			key += "}"
+			if gauge := metric.GetGauge(); gauge != nil {
+				values[key] = gauge.GetValue()
+			} else {
+				values[key] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+func TestMetrics_ConcurrentGather(t *testing.T) {
+	m := NewMetrics("test")
+	reg := prometheus.NewRegistry()
+	for _, collector := range m.Collectors() {
+		require.NoError(t, reg.Register(collector))
+	}
+	group, err := NewPoolGroupFromCIDRs(FillFirst, []string{"10.20.0.0/24"})
+	require.NoError(t, err)
+	require.NoError(t, m.AddGroup("ims", group))
+	require.NoError(t, m.AddPool("ims-0", group.Pools()[0]))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			_, gatherErr := reg.Gather()
+			assert.NoError(t, gatherErr)
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		ip, allocErr := group.Allocate(nil)
+		require.NoError(t, allocErr)
+		require.NoError(t, group.Release(ip))
+	}
+	<-done
+	m.Remove("ims-0")
+	m.Remove("ims")
+}
+
+func TestMetrics_LeaseExpiry(t *testing.T) {
+	m := NewMetrics("test")
+	clock := newFakeClock()
+	pool, err := NewIPPool("10.10.0.0/29", WithClock(clock))
+	require.NoError(t, err)
+	require.NoError(t, m.AddPool("internet", pool))
+	var levels []WatermarkLevel
+	require.NoError(t, m.SetWatermarks("internet", 0.75, 0.5, func(name string, level WatermarkLevel, u float64) {
+		levels = append(levels, level)
+	}))
+
+	for i := 0; i < 4; i++ {
+		_, err = pool.AllocateLease(nil, time.Minute)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []WatermarkLevel{WatermarkHigh}, levels)
+
+	// the addresses freed by expired leases are reported
+	clock.Advance(time.Minute)
+	assert.Equal(t, []WatermarkLevel{WatermarkHigh, WatermarkLow}, levels)
+}
@@ -0,0 +1,248 @@
+package ippool
+
+import (
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// AllocationPolicy decides which member of a PoolGroup serves an allocation
+type AllocationPolicy int
+
+const (
+	// FillFirst allocates from the first member which is not exhausted
+	FillFirst AllocationPolicy = iota
+	// RoundRobin allocates from each member in turn
+	RoundRobin
+	// LeastUtilized allocates from the member with the lowest ratio of allocated addresses
+	LeastUtilized
+)
+
+func (policy AllocationPolicy) String() string {
+	switch policy {
+	case FillFirst:
+		return "FillFirst"
+	case RoundRobin:
+		return "RoundRobin"
+	case LeastUtilized:
+		return "LeastUtilized"
+	default:
+		return "Unknown"
+	}
+}
+
+// PoolGroup aggregates the IPPools of one DNN/S-NSSAI, e.g. several non-contiguous subnets
+type PoolGroup struct {
+	mtx    sync.Mutex
+	pools  []*IPPool
+	policy AllocationPolicy
+	// next is the member to try first with RoundRobin
+	next int
+	// metrics is set by Metrics.AddGroup
+	metrics atomic.Pointer[metricsBinding]
+}
+
+// NewPoolGroup makes a PoolGroup of pools, the subnets of pools must not overlap
+func NewPoolGroup(policy AllocationPolicy, pools ...*IPPool) (*PoolGroup, error) {
+	if policy < FillFirst || policy > LeastUtilized {
+		return nil, errors.Errorf("Unknown allocation policy: %d", policy)
+	}
+	g := &PoolGroup{policy: policy}
+	for _, pool := range pools {
+		if err := g.AddPool(pool); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// NewPoolGroupFromCIDRs makes a PoolGroup with an IPPool for each CIDR, opts are applied to every IPPool
+func NewPoolGroupFromCIDRs(policy AllocationPolicy, cidrs []string, opts ...PoolOption) (*PoolGroup, error) {
+	var pools []*IPPool
+	for _, cidr := range cidrs {
+		pool, err := NewIPPool(cidr, opts...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewPoolGroupFromCIDRs")
+		}
+		pools = append(pools, pool)
+	}
+	return NewPoolGroup(policy, pools...)
+}
+
+// AddPool adds pool as the last member of the group
+func (g *PoolGroup) AddPool(pool *IPPool) error {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	for _, member := range g.pools {
+		if member.Subnet().Contains(pool.Subnet().IP) || pool.Subnet().Contains(member.Subnet().IP) {
+			return errors.Errorf("Pool[%+v] overlaps Pool[%+v]", pool.Subnet(), member.Subnet())
+		}
+	}
+	g.pools = append(g.pools, pool)
+	return nil
+}
+
+// Pools returns the members of the group
+func (g *PoolGroup) Pools() []*IPPool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return append([]*IPPool(nil), g.pools...)
+}
+
+// Policy returns the allocation policy of the group
+func (g *PoolGroup) Policy() AllocationPolicy {
+	return g.policy
+}
+
+// PoolOf returns the member whose subnet contains ip, or nil
+func (g *PoolGroup) PoolOf(ip net.IP) *IPPool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.poolOf(ip)
+}
+
+func (g *PoolGroup) poolOf(ip net.IP) *IPPool {
+	for _, pool := range g.pools {
+		if pool.Subnet().Contains(ip) {
+			return pool
+		}
+	}
+	return nil
+}
+
+// Allocate allocates request from the member containing it,
+// or an address from a member chosen by the allocation policy if request is nil
+func (g *PoolGroup) Allocate(request net.IP) (net.IP, error) {
+	ip, err := g.allocate(request)
+	g.report(err != nil)
+	return ip, err
+}
+
+func (g *PoolGroup) allocate(request net.IP) (net.IP, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if request != nil {
+		pool := g.poolOf(request)
+		if pool == nil {
+			return nil, errors.Errorf("IP[%s] is out of pool group", request)
+		}
+		return pool.Allocate(request)
+	}
+
+	for _, index := range g.candidates() {
+		if g.pools[index].Pool.Remain() == 0 {
+			continue
+		}
+		ip, err := g.pools[index].Allocate(nil)
+		if err == nil {
+			if g.policy == RoundRobin {
+				g.next = (index + 1) % len(g.pools)
+			}
+			return ip, nil
+		}
+		if errors.Is(err, ErrJournal) {
+			return nil, err
+		}
+	}
+	return nil, errors.New("Pool group is empty")
+}
+
+// candidates returns the indexes of members in the order they are tried by the allocation policy
+func (g *PoolGroup) candidates() []int {
+	indexes := make([]int, len(g.pools))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	switch g.policy {
+	case RoundRobin:
+		for i := range indexes {
+			indexes[i] = (g.next + i) % len(g.pools)
+		}
+	case LeastUtilized:
+		utilization := make([]float64, len(g.pools))
+		for i, pool := range g.pools {
+			total := pool.Pool.Total()
+			utilization[i] = float64(total-pool.Pool.Remain()) / float64(total)
+		}
+		sort.SliceStable(indexes, func(i, j int) bool {
+			return utilization[indexes[i]] < utilization[indexes[j]]
+		})
+	}
+	return indexes
+}
+
+// Reallocate marks request as used in the member containing it, see IPPool.Reallocate
+func (g *PoolGroup) Reallocate(request net.IP) (net.IP, bool) {
+	pool := g.PoolOf(request)
+	if pool == nil {
+		return nil, false
+	}
+	ip, inUsed := pool.Reallocate(request)
+	g.report(false)
+	return ip, inUsed
+}
+
+// Release returns ip to the member containing it
+func (g *PoolGroup) Release(ip net.IP) error {
+	pool := g.PoolOf(ip)
+	if pool == nil {
+		return errors.Errorf("failed to release UE Address out of pool group: %s", ip)
+	}
+	if err := pool.Release(ip); err != nil {
+		return err
+	}
+	g.report(false)
+	return nil
+}
+
+// Remain returns the number of free addresses of all members, saturated at math.MaxInt
+func (g *PoolGroup) Remain() int {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	remain := 0
+	for _, pool := range g.pools {
+		remain = saturatingAdd(remain, pool.Pool.Remain())
+	}
+	return remain
+}
+
+// Total returns the number of addresses of all members, saturated at math.MaxInt
+func (g *PoolGroup) Total() int {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	total := 0
+	for _, pool := range g.pools {
+		total = saturatingAdd(total, pool.Pool.Total())
+	}
+	return total
+}
+
+// usage returns the number of addresses and free addresses of all members,
+// as float64 so the sums of large IPv6 pools do not overflow
+func (g *PoolGroup) usage() (total, free float64) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	for _, pool := range g.pools {
+		total += float64(pool.Pool.Total())
+		free += float64(pool.Pool.Remain())
+	}
+	return total, free
+}
+
+// saturatingAdd returns a+b, or math.MaxInt if it overflows, a and b must not be negative
+func saturatingAdd(a, b int) int {
+	if a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
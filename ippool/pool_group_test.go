@@ -0,0 +1,110 @@
+package ippool
+
+import (
+	"math"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolGroup_FillFirst(t *testing.T) {
+	g, err := NewPoolGroupFromCIDRs(FillFirst, []string{"10.60.0.0/30", "10.61.0.0/30"})
+	require.NoError(t, err)
+	require.Equal(t, 4, g.Remain())
+	require.Equal(t, 8, g.Total())
+
+	var allocated []net.IP
+	for i := 0; i < 4; i++ {
+		ip, err := g.Allocate(nil)
+		require.NoError(t, err)
+		allocated = append(allocated, ip)
+	}
+	require.Equal(t, []net.IP{
+		net.ParseIP("10.60.0.1").To4(),
+		net.ParseIP("10.60.0.2").To4(),
+		net.ParseIP("10.61.0.1").To4(),
+		net.ParseIP("10.61.0.2").To4(),
+	}, allocated)
+
+	_, err = g.Allocate(nil)
+	require.EqualError(t, err, "Pool group is empty")
+
+	// release is routed to the member pool
+	require.NoError(t, g.Release(net.ParseIP("10.61.0.1")))
+	require.Equal(t, 1, g.Pools()[1].Pool.Remain())
+	require.Error(t, g.Release(net.ParseIP("10.62.0.1")))
+
+	ip, err := g.Allocate(nil)
+	require.NoError(t, err)
+	require.Equal(t, net.ParseIP("10.61.0.1").To4(), ip)
+}
+
+func TestPoolGroup_RoundRobin(t *testing.T) {
+	g, err := NewPoolGroupFromCIDRs(RoundRobin, []string{"10.60.0.0/29", "10.61.0.0/30", "10.62.0.0/29"})
+	require.NoError(t, err)
+
+	var allocated []string
+	for i := 0; i < 7; i++ {
+		ip, err := g.Allocate(nil)
+		require.NoError(t, err)
+		allocated = append(allocated, ip.String())
+	}
+	require.Equal(t, []string{
+		"10.60.0.1", "10.61.0.1", "10.62.0.1",
+		"10.60.0.2", "10.61.0.2", "10.62.0.2",
+		// 10.61.0.0/30 is exhausted
+		"10.60.0.3",
+	}, allocated)
+}
+
+func TestPoolGroup_LeastUtilized(t *testing.T) {
+	g, err := NewPoolGroupFromCIDRs(LeastUtilized, []string{"10.60.0.0/29", "10.61.0.0/30"})
+	require.NoError(t, err)
+
+	// 10.61.0.0/30 has 2 used entries of 4 (network id and broadcast)
+	ip, err := g.Allocate(nil)
+	require.NoError(t, err)
+	require.Equal(t, "10.60.0.1", ip.String())
+
+	_, err = g.Allocate(net.ParseIP("10.60.0.2"))
+	require.NoError(t, err)
+	ip, err = g.Allocate(nil)
+	require.NoError(t, err)
+	require.Equal(t, "10.60.0.3", ip.String())
+
+	// 10.60.0.0/29 has 5 used entries of 8
+	ip, err = g.Allocate(nil)
+	require.NoError(t, err)
+	require.Equal(t, "10.61.0.1", ip.String())
+
+	reallocIP, used := g.Reallocate(net.ParseIP("10.61.0.1"))
+	require.True(t, used)
+	require.Equal(t, "10.61.0.1", reallocIP.String())
+}
+
+func TestPoolGroup_Invalid(t *testing.T) {
+	_, err := NewPoolGroupFromCIDRs(FillFirst, []string{"10.60.0.0/16", "10.60.1.0/24"})
+	require.EqualError(t, err, "Pool[10.60.1.0/24] overlaps Pool[10.60.0.0/16]")
+
+	_, err = NewPoolGroup(AllocationPolicy(10))
+	require.Error(t, err)
+
+	g, err := NewPoolGroup(FillFirst)
+	require.NoError(t, err)
+	_, err = g.Allocate(net.ParseIP("10.60.0.1"))
+	require.Error(t, err)
+}
+
+func TestPoolGroup_LargeIPv6(t *testing.T) {
+	g, err := NewPoolGroupFromCIDRs(FillFirst, []string{"2001:db8::/66", "2001:db8:0:0:4000::/66", "2001:db8:1::/66"})
+	require.NoError(t, err)
+	require.Equal(t, math.MaxInt, g.Total())
+	require.Equal(t, math.MaxInt, g.Remain())
+
+	_, err = g.Allocate(nil)
+	require.NoError(t, err)
+	total, free := g.usage()
+	require.Equal(t, float64(3<<62), total)
+	require.InEpsilon(t, total, free, 1e-9)
+}
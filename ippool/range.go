@@ -0,0 +1,138 @@
+package ippool
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Range is the values of [First, Last]
+type Range struct {
+	First int `json:"first"`
+	Last  int `json:"last"`
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("[%d, %d]", r.First, r.Last)
+}
+
+// Contains returns true if value is in r
+func (r Range) Contains(value int) bool {
+	return r.First <= value && value <= r.Last
+}
+
+// Union returns the values in a or b.
+// The ranges of the set operations may be unsorted and overlapping, the results are sorted and
+// merged, i.e. no two ranges of a result overlap or are adjacent.
+func Union(a, b []Range) []Range {
+	return normalizeRanges(append(append([]Range(nil), a...), b...))
+}
+
+// Intersection returns the values both in a and b
+func Intersection(a, b []Range) []Range {
+	return intersectRanges(normalizeRanges(a), normalizeRanges(b))
+}
+
+// Difference returns the values in a but not in b
+func Difference(a, b []Range) []Range {
+	return subtractRanges(normalizeRanges(a), normalizeRanges(b))
+}
+
+// IsSubset returns true if all values of a are in b
+func IsSubset(a, b []Range) bool {
+	return len(Difference(a, b)) == 0
+}
+
+// normalizeRanges sorts ranges, merges the overlapping or adjacent ones and drops the empty ones
+func normalizeRanges(ranges []Range) []Range {
+	sorted := make([]Range, 0, len(ranges))
+	for _, r := range ranges {
+		if r.First <= r.Last {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].First < sorted[j].First })
+
+	var normalized []Range
+	for _, r := range sorted {
+		if n := len(normalized); n > 0 {
+			if prev := &normalized[n-1]; r.First <= prev.Last || r.First-1 == prev.Last {
+				if r.Last > prev.Last {
+					prev.Last = r.Last
+				}
+				continue
+			}
+		}
+		normalized = append(normalized, r)
+	}
+	return normalized
+}
+
+// subtractRanges returns the values of a not in b, a and b must be normalized
+func subtractRanges(a, b []Range) []Range {
+	var result []Range
+	j := 0
+	for _, r := range a {
+		for j < len(b) && b[j].Last < r.First {
+			j++
+		}
+		first, covered := r.First, false
+		for k := j; k < len(b) && b[k].First <= r.Last; k++ {
+			if b[k].First > first {
+				result = append(result, Range{First: first, Last: b[k].First - 1})
+			}
+			if b[k].Last >= r.Last {
+				covered = true
+				break
+			}
+			first = b[k].Last + 1
+		}
+		if !covered {
+			result = append(result, Range{First: first, Last: r.Last})
+		}
+	}
+	return result
+}
+
+// intersectRanges returns the values both in a and b, a and b must be normalized
+func intersectRanges(a, b []Range) []Range {
+	return subtractRanges(a, subtractRanges(a, b))
+}
+
+// Range returns [Min, Max] of the pool, e.g. for the set operations between pools
+func (p *LazyReusePool) Range() Range {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return Range{First: p.first, Last: p.last}
+}
+
+// ReserveRanges removes all ranges from the pool atomically: nothing is reserved
+// if any range is out of the pool.
+func (p *LazyReusePool) ReserveRanges(ranges []Range) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	// checked under the lock, the range may be changed by Resize
+	for _, r := range ranges {
+		if !p.contains(r.First, r.Last) {
+			return errors.Errorf("reserve range %s should in [%d, %d]", r, p.first, p.last)
+		}
+	}
+	for _, r := range normalizeRanges(ranges) {
+		p.reserve(r.First, r.Last)
+	}
+	return nil
+}
+
+// freeRanges returns the free values of the pool in ascending order
+func (p *LazyReusePool) freeRanges() []Range {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var ranges []Range
+	for cur := p.head; cur != nil; cur = cur.next {
+		ranges = append(ranges, Range{First: cur.first, Last: cur.last})
+	}
+	return normalizeRanges(ranges)
+}
@@ -0,0 +1,52 @@
+package ippool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeSetOperations(t *testing.T) {
+	a := []Range{{First: 20, Last: 29}, {First: 0, Last: 9}, {First: 5, Last: 12}}
+	b := []Range{{First: 10, Last: 24}, {First: 40, Last: 40}, {First: 3, Last: 1}}
+
+	assert.Equal(t, []Range{{First: 0, Last: 29}, {First: 40, Last: 40}}, Union(a, b))
+	assert.Equal(t, []Range{{First: 10, Last: 12}, {First: 20, Last: 24}}, Intersection(a, b))
+	assert.Equal(t, []Range{{First: 0, Last: 9}, {First: 25, Last: 29}}, Difference(a, b))
+	assert.Equal(t, []Range{{First: 13, Last: 19}, {First: 40, Last: 40}}, Difference(b, a))
+	assert.Empty(t, Intersection(a, []Range{{First: 13, Last: 19}}))
+
+	assert.True(t, IsSubset([]Range{{First: 1, Last: 4}, {First: 21, Last: 22}}, a))
+	assert.True(t, IsSubset(nil, a))
+	assert.False(t, IsSubset(b, a))
+	assert.True(t, IsSubset(Intersection(a, b), b))
+}
+
+func TestLazyReusePool_RangeSetOperations(t *testing.T) {
+	p1, err := NewLazyReusePool(10, 19)
+	require.NoError(t, err)
+	p2, err := NewLazyReusePool(15, 29)
+	require.NoError(t, err)
+
+	assert.Equal(t, Range{First: 10, Last: 19}, p1.Range())
+	assert.Equal(t, []Range{{First: 15, Last: 19}}, Intersection([]Range{p1.Range()}, []Range{p2.Range()}))
+	assert.Equal(t, []Range{{First: 10, Last: 29}}, Union([]Range{p1.Range()}, []Range{p2.Range()}))
+	assert.False(t, IsSubset([]Range{p2.Range()}, []Range{p1.Range()}))
+}
+
+func TestLazyReusePool_ReserveRanges(t *testing.T) {
+	p, err := NewLazyReusePool(0, 99)
+	require.NoError(t, err)
+
+	// nothing is reserved if any range is out of the pool
+	err = p.ReserveRanges([]Range{{First: 10, Last: 19}, {First: 90, Last: 100}})
+	require.EqualError(t, err, "reserve range [90, 100] should in [0, 99]")
+	require.Error(t, p.ReserveRanges([]Range{{First: 10, Last: 19}, {First: 30, Last: 29}}))
+	assert.Equal(t, 100, p.Remain())
+	assert.Equal(t, [][]int{{0, 99}}, p.Dump())
+
+	require.NoError(t, p.ReserveRanges([]Range{{First: 50, Last: 59}, {First: 0, Last: 4}, {First: 55, Last: 64}}))
+	assert.Equal(t, 80, p.Remain())
+	assert.Equal(t, [][]int{{5, 49}, {65, 99}}, p.Dump())
+}
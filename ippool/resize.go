@@ -0,0 +1,152 @@
+package ippool
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// Resize changes the range of the pool to [first, last] in place. The values added to the range become
+// free, and the values removed from the range are dropped from the pool.
+// It returns the values removed from the range which are allocated or leased, the values taken by
+// Reserve are dropped silently; the leases and tracked allocations of the returned values are dropped
+// and their release is journaled.
+func (p *LazyReusePool) Resize(first, last int) ([]int, error) {
+	if first > last {
+		return nil, errors.Errorf("Resize: invalid range [%d, %d]", first, last)
+	}
+	return p.resize(Range{First: first, Last: last}, nil, []Range{{First: first, Last: last}})
+}
+
+// resize changes the range of the pool to r and the values which can be allocated to usable.
+// oldUsable are the values which could be allocated before, the whole range if nil.
+// It returns the values in use which are not usable anymore, see Resize.
+func (p *LazyReusePool) resize(r Range, oldUsable, usable []Range) ([]int, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.releaseQuarantined()
+	if oldUsable == nil {
+		oldUsable = []Range{{First: p.first, Last: p.last}}
+	}
+	usable = intersectRanges(normalizeRanges(usable), []Range{r})
+
+	var free []Range
+	for cur := p.head; cur != nil; cur = cur.next {
+		free = append(free, Range{First: cur.first, Last: cur.last})
+	}
+	idle := make([]Range, 0, len(free)+len(p.quarantined.values))
+	idle = append(idle, free...)
+	for value := range p.quarantined.values {
+		idle = append(idle, Range{First: value, Last: value})
+	}
+	// the reserved values are not allocated, so they are dropped silently
+	inUse := Difference(oldUsable, Union(idle, p.reserved))
+
+	var lost []int
+	for _, l := range subtractRanges(inUse, usable) {
+		for value := l.First; ; value++ {
+			lost = append(lost, value)
+			if value == l.Last {
+				break
+			}
+		}
+	}
+	if p.journal != nil {
+		for i, value := range lost {
+			if err := p.journal.Released(value); err != nil {
+				// keep the journal consistent with the unchanged pool
+				for _, released := range lost[:i] {
+					_ = p.journal.Allocated(released)
+				}
+				return nil, errors.Wrapf(err, "Resize: journal release of %d", value)
+			}
+		}
+	}
+	for _, value := range lost {
+		p.cancelLease(value)
+		p.untrack(value)
+	}
+	for _, q := range Difference([]Range{{First: p.first, Last: p.last}}, usable) {
+		p.quarantined.remove(q.First, q.Last)
+	}
+
+	// the head is kept if it is still usable, so the lazy reuse order is preserved
+	newFree := Union(intersectRanges(normalizeRanges(free), usable), Difference(usable, oldUsable))
+	if len(newFree) > 0 && p.head != nil {
+		for i, f := range newFree {
+			if f.First <= p.head.last && p.head.first <= f.Last {
+				newFree[0], newFree[i] = newFree[i], newFree[0]
+				break
+			}
+		}
+	}
+	p.head, p.index, p.order, p.remain = buildSegments(newFree)
+	// the values of r which are not usable, e.g. the new broadcast address, stay reserved
+	p.reserved = Union(Difference(Intersection(p.reserved, []Range{r}), Difference(usable, oldUsable)),
+		Difference([]Range{r}, usable))
+	p.first, p.last = r.First, r.Last
+	return lost, nil
+}
+
+// Resize changes the subnet of the pool to cidr in place, the live allocations in cidr are kept.
+// Subnet returns cidr afterwards, the deprecated IPSubnet keeps the subnet the pool was made of.
+// For IPv6, cidr must start at the same address as Subnet, e.g. 2001:db8::/63 for 2001:db8::/64.
+//
+// It returns the allocated addresses which are not in cidr anymore (including the new network id
+// and broadcast address of IPv4), so their sessions can be released; they must not be passed to
+// Release. The addresses excluded by Exclude are not reported, the exclusions and the static
+// reservations outside cidr are removed.
+func (p *IPPool) Resize(cidr string) ([]net.IP, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Resize ParseCIDR")
+	}
+	if (ipNet.IP.To4() != nil) != p.IsIPv4() {
+		return nil, errors.Errorf("Resize: %s and %+v are not the same address family", cidr, p.Subnet())
+	}
+	if !p.IsIPv4() && !ipNet.IP.Equal(p.Subnet().IP) {
+		return nil, errors.Errorf("Resize: %s does not start at %s", cidr, p.Subnet().IP)
+	}
+
+	oldTemplate, err := p.template(p.Subnet())
+	if err != nil {
+		return nil, err
+	}
+	newTemplate, err := p.template(ipNet)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Resize")
+	}
+
+	p.staticMtx.Lock()
+	defer p.staticMtx.Unlock()
+
+	lost, err := p.Pool.resize(newTemplate.Pool.Range(), oldTemplate.Pool.freeRanges(), newTemplate.Pool.freeRanges())
+	if err != nil {
+		return nil, err
+	}
+	p.subnet.Store(ipNet)
+
+	// lost is in ascending order
+	var ips []net.IP
+	for _, value := range lost {
+		if static, ok := p.staticOf[value]; ok {
+			delete(p.statics, static.supi)
+			delete(p.staticOf, value)
+			if !static.allocated {
+				continue
+			}
+		}
+		ips = append(ips, p.ipOf(value))
+	}
+	p.report(false)
+	return ips, nil
+}
+
+// template makes an empty pool of ipNet like p, whose free ranges are the usable values of ipNet
+func (p *IPPool) template(ipNet *net.IPNet) (*IPPool, error) {
+	if p.IsIPv4() {
+		return NewIPPool(ipNet.String())
+	}
+	return newIPv6Pool(ipNet.String(), ipNet, p.prefixLen, p.reserveAnycast, nil)
+}
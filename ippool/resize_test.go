@@ -0,0 +1,196 @@
+package ippool
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLazyReusePool_Resize(t *testing.T) {
+	clock := newFakeClock()
+	p, err := NewLazyReusePool(0, 9, WithClock(clock), WithQuarantine(time.Minute))
+	require.NoError(t, err)
+	var expired []int
+	p.OnLeaseExpired(func(value int) {
+		expired = append(expired, value)
+	})
+	for value := 0; value < 8; value++ {
+		require.True(t, p.Use(value))
+	}
+	require.True(t, p.UseLease(8, time.Hour))
+	require.True(t, p.Use(9))
+	require.True(t, p.Free(9))
+	require.True(t, p.Free(2))
+	assert.Equal(t, 2, p.Quarantined())
+
+	// grow, the new values are free
+	lost, err := p.Resize(0, 14)
+	require.NoError(t, err)
+	assert.Empty(t, lost)
+	assert.Equal(t, 15, p.Total())
+	assert.Equal(t, 5, p.Remain())
+	assert.Equal(t, 2, p.Quarantined())
+
+	// shrink, the values in use out of the range are reported
+	lost, err = p.Resize(2, 7)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 8}, lost)
+	assert.Equal(t, 6, p.Total())
+	assert.Equal(t, 0, p.Remain())
+	assert.Equal(t, 1, p.Quarantined())
+
+	lost, err = p.Resize(2, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []int{6, 7}, lost)
+
+	clock.Advance(time.Hour)
+	assert.Empty(t, expired)
+	assert.Equal(t, 0, p.Quarantined())
+	assert.Equal(t, 1, p.Remain())
+	assert.Equal(t, [][]int{{2, 2}}, p.Dump())
+
+	_, err = p.Resize(5, 2)
+	require.Error(t, err)
+}
+
+func TestLazyReusePool_ResizeLeased(t *testing.T) {
+	clock := newFakeClock()
+	p, err := NewLazyReusePool(0, 9, WithClock(clock))
+	require.NoError(t, err)
+	var expired []int
+	p.OnLeaseExpired(func(value int) {
+		expired = append(expired, value)
+	})
+	require.True(t, p.UseLease(8, time.Hour))
+	require.True(t, p.UseLease(3, time.Hour))
+
+	lost, err := p.Resize(0, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []int{8}, lost)
+	clock.Advance(time.Hour)
+	assert.Equal(t, []int{3}, expired)
+	assert.Equal(t, 6, p.Remain())
+}
+
+func TestIPPool_Resize(t *testing.T) {
+	ipOf := func(s string) net.IP {
+		return net.ParseIP(s).To4()
+	}
+	pool, err := NewIPPool("10.10.0.0/29")
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		_, err = pool.Allocate(nil)
+		require.NoError(t, err)
+	}
+
+	// grow, the old broadcast address becomes free
+	lost, err := pool.Resize("10.10.0.0/28")
+	require.NoError(t, err)
+	assert.Empty(t, lost)
+	assert.Equal(t, "10.10.0.0/28", pool.Subnet().String())
+	assert.Equal(t, 8, pool.Pool.Remain())
+	ip, err := pool.Allocate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, ipOf("10.10.0.7"), ip)
+	_, err = pool.Allocate(ipOf("10.10.0.12"))
+	require.NoError(t, err)
+	require.NoError(t, pool.AddStaticIP("imsi-208930000000001", ipOf("10.10.0.13")))
+	require.NoError(t, pool.AddStaticIP("imsi-208930000000002", ipOf("10.10.0.14")))
+	_, err = pool.AllocateStaticIP("imsi-208930000000002")
+	require.NoError(t, err)
+	require.NoError(t, pool.Release(ipOf("10.10.0.3")))
+
+	// shrink, the new broadcast address and the addresses out of the subnet are reported
+	lost, err = pool.Resize("10.10.0.0/29")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{ipOf("10.10.0.7"), ipOf("10.10.0.12"), ipOf("10.10.0.14")}, lost)
+	assert.Equal(t, 1, pool.Pool.Remain())
+	_, ok := pool.StaticIP("imsi-208930000000001")
+	assert.False(t, ok)
+	ip, err = pool.Allocate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, ipOf("10.10.0.3"), ip)
+	_, err = pool.Allocate(nil)
+	require.Error(t, err)
+	require.Error(t, pool.Release(ipOf("10.10.0.12")))
+
+	_, err = pool.Resize("2001:db8::/64")
+	require.Error(t, err)
+}
+
+func TestIPPool_ResizeExcluded(t *testing.T) {
+	pool, err := NewIPPool("10.0.0.0/16")
+	require.NoError(t, err)
+	excluded, err := NewIPPool("10.0.128.0/17")
+	require.NoError(t, err)
+	require.NoError(t, pool.Exclude(excluded))
+	ip, err := pool.Allocate(nil)
+	require.NoError(t, err)
+
+	// the excluded addresses out of the subnet are not allocations
+	lost, err := pool.Resize("10.0.0.0/17")
+	require.NoError(t, err)
+	assert.Empty(t, lost)
+	assert.Equal(t, 1<<15-3, pool.Pool.Remain())
+	require.NoError(t, pool.Release(ip))
+
+	// the exclusion out of the subnet was dropped
+	lost, err = pool.Resize("10.0.0.0/16")
+	require.NoError(t, err)
+	assert.Empty(t, lost)
+	assert.Equal(t, 1<<16-2, pool.Pool.Remain())
+}
+
+func TestIPPool_ResizeIPv6(t *testing.T) {
+	pool, err := NewIPv6PrefixPool("2001:db8::/62", 64)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = pool.Allocate(nil)
+		require.NoError(t, err)
+	}
+
+	lost, err := pool.Resize("2001:db8::/63")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8:0:2::")}, lost)
+	assert.Equal(t, 0, pool.Pool.Remain())
+
+	lost, err = pool.Resize("2001:db8::/61")
+	require.NoError(t, err)
+	assert.Empty(t, lost)
+	assert.Equal(t, 6, pool.Pool.Remain())
+
+	_, err = pool.Resize("2001:db8:0:8::/61")
+	require.Error(t, err)
+}
+
+func TestLazyReusePool_ResizeConcurrentReserve(t *testing.T) {
+	p, err := NewLazyReusePool(0, 99)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, resizeErr := p.Resize(0, 49+i%2*50)
+			assert.NoError(t, resizeErr)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			// the range is checked against the range of the pool at the time of the reservation
+			_ = p.Reserve(60+i%10, 60+i%10)
+			_ = p.ReserveRanges([]Range{{First: 70, Last: 70}})
+			_ = p.Contains(0, 99)
+		}
+	}()
+	wg.Wait()
+	for _, seg := range p.Dump() {
+		assert.True(t, p.Contains(seg[0], seg[1]), seg)
+	}
+}
@@ -0,0 +1,301 @@
+package ippool
+
+// segmentTree is a treap of segments ordered by their first value, it indexes the segments
+// following the head of a LazyReusePool so the segment of a value is found in O(log n).
+// The first value of an indexed segment may be changed in place as long as the order of
+// the segments is kept.
+type segmentTree struct {
+	root *segmentNode
+	// seed of the priorities of nodes
+	seed uint64
+}
+
+type segmentNode struct {
+	seg         *segment
+	priority    uint64
+	left, right *segmentNode
+}
+
+// random returns the next priority by xorshift64*
+func (t *segmentTree) random() uint64 {
+	if t.seed == 0 {
+		t.seed = 0x9E3779B97F4A7C15
+	}
+	t.seed ^= t.seed >> 12
+	t.seed ^= t.seed << 25
+	t.seed ^= t.seed >> 27
+	return t.seed * 0x2545F4914F6CDD1D
+}
+
+func (t *segmentTree) insert(seg *segment) {
+	left, right := splitSegmentNode(t.root, seg.first)
+	node := &segmentNode{seg: seg, priority: t.random()}
+	t.root = mergeSegmentNode(mergeSegmentNode(left, node), right)
+}
+
+// remove removes the segment beginning with first
+func (t *segmentTree) remove(first int) {
+	t.root = removeSegmentNode(t.root, first)
+}
+
+// floor returns the segment with the greatest first value <= value, or nil
+func (t *segmentTree) floor(value int) *segment {
+	var found *segment
+	for node := t.root; node != nil; {
+		if node.seg.first <= value {
+			found = node.seg
+			node = node.right
+		} else {
+			node = node.left
+		}
+	}
+	return found
+}
+
+// higher returns the segment with the least first value > value, or nil
+func (t *segmentTree) higher(value int) *segment {
+	var found *segment
+	for node := t.root; node != nil; {
+		if node.seg.first > value {
+			found = node.seg
+			node = node.left
+		} else {
+			node = node.right
+		}
+	}
+	return found
+}
+
+// lower returns the segment with the greatest first value < value, or nil
+func (t *segmentTree) lower(value int) *segment {
+	var found *segment
+	for node := t.root; node != nil; {
+		if node.seg.first < value {
+			found = node.seg
+			node = node.right
+		} else {
+			node = node.left
+		}
+	}
+	return found
+}
+
+// splitSegmentNode splits the tree of node into the segments beginning before key and the others
+func splitSegmentNode(node *segmentNode, key int) (left, right *segmentNode) {
+	if node == nil {
+		return nil, nil
+	}
+	if node.seg.first < key {
+		node.right, right = splitSegmentNode(node.right, key)
+		return node, right
+	}
+	left, node.left = splitSegmentNode(node.left, key)
+	return left, node
+}
+
+// mergeSegmentNode joins two trees, all segments of left must be before those of right
+func mergeSegmentNode(left, right *segmentNode) *segmentNode {
+	switch {
+	case left == nil:
+		return right
+	case right == nil:
+		return left
+	case left.priority > right.priority:
+		left.right = mergeSegmentNode(left.right, right)
+		return left
+	default:
+		right.left = mergeSegmentNode(left, right.left)
+		return right
+	}
+}
+
+func removeSegmentNode(node *segmentNode, first int) *segmentNode {
+	if node == nil {
+		return nil
+	}
+	switch {
+	case first < node.seg.first:
+		node.left = removeSegmentNode(node.left, first)
+	case first > node.seg.first:
+		node.right = removeSegmentNode(node.right, first)
+	default:
+		return mergeSegmentNode(node.left, node.right)
+	}
+	return node
+}
+
+// orderTree is a treap of the segments following the head of a LazyReusePool in the order of
+// the list, i.e. the order they are allocated in. Each node keeps the greatest last value of its
+// subtree, so the first segment of the list reaching a value is found in O(log n), see reaching.
+type orderTree struct {
+	root *orderNode
+	// nodes maps the segments to their nodes
+	nodes map[*segment]*orderNode
+	// seed of the priorities of nodes
+	seed uint64
+}
+
+type orderNode struct {
+	seg                 *segment
+	priority            uint64
+	left, right, parent *orderNode
+	// size is the number of nodes of the subtree, maxLast the greatest last value in it
+	size    int
+	maxLast int
+}
+
+// random returns the next priority by xorshift64*
+func (t *orderTree) random() uint64 {
+	if t.seed == 0 {
+		t.seed = 0x9E3779B97F4A7C15
+	}
+	t.seed ^= t.seed >> 12
+	t.seed ^= t.seed << 25
+	t.seed ^= t.seed >> 27
+	return t.seed * 0x2545F4914F6CDD1D
+}
+
+// insert puts seg at position rank of the list
+func (t *orderTree) insert(rank int, seg *segment) {
+	if t.nodes == nil {
+		t.nodes = make(map[*segment]*orderNode)
+	}
+	node := &orderNode{seg: seg, priority: t.random()}
+	pullOrderNode(node)
+	t.nodes[seg] = node
+	left, right := splitOrderNode(t.root, rank)
+	t.setRoot(mergeOrderNode(mergeOrderNode(left, node), right))
+}
+
+// remove takes seg out of the list
+func (t *orderTree) remove(seg *segment) {
+	left, right := splitOrderNode(t.root, t.rank(seg))
+	_, right = splitOrderNode(right, 1)
+	t.setRoot(mergeOrderNode(left, right))
+	delete(t.nodes, seg)
+}
+
+// rank returns the position of seg in the list
+func (t *orderTree) rank(seg *segment) int {
+	node := t.nodes[seg]
+	rank := orderNodeSize(node.left)
+	for ; node.parent != nil; node = node.parent {
+		if node == node.parent.right {
+			rank += orderNodeSize(node.parent.left) + 1
+		}
+	}
+	return rank
+}
+
+// update must be called after the last value of seg is changed
+func (t *orderTree) update(seg *segment) {
+	for node := t.nodes[seg]; node != nil; node = node.parent {
+		pullOrderNode(node)
+	}
+}
+
+// reaching returns the first segment of the list whose last value is >= value, or nil
+func (t *orderTree) reaching(value int) *segment {
+	node := t.root
+	for node != nil && node.maxLast >= value {
+		switch {
+		case node.left != nil && node.left.maxLast >= value:
+			node = node.left
+		case node.seg.last >= value:
+			return node.seg
+		default:
+			node = node.right
+		}
+	}
+	return nil
+}
+
+// prev returns the segment before seg in the list, nil if seg is the first one
+func (t *orderTree) prev(seg *segment) *segment {
+	node := t.nodes[seg]
+	if node.left != nil {
+		node = node.left
+		for node.right != nil {
+			node = node.right
+		}
+		return node.seg
+	}
+	for ; node.parent != nil; node = node.parent {
+		if node == node.parent.right {
+			return node.parent.seg
+		}
+	}
+	return nil
+}
+
+// tail returns the last segment of the list, or nil
+func (t *orderTree) tail() *segment {
+	node := t.root
+	if node == nil {
+		return nil
+	}
+	for node.right != nil {
+		node = node.right
+	}
+	return node.seg
+}
+
+func (t *orderTree) setRoot(root *orderNode) {
+	t.root = root
+	if root != nil {
+		root.parent = nil
+	}
+}
+
+func orderNodeSize(node *orderNode) int {
+	if node == nil {
+		return 0
+	}
+	return node.size
+}
+
+// pullOrderNode recomputes the size and maxLast of node from its children
+func pullOrderNode(node *orderNode) {
+	node.size = 1
+	node.maxLast = node.seg.last
+	for _, child := range []*orderNode{node.left, node.right} {
+		if child != nil {
+			child.parent = node
+			node.size += child.size
+			node.maxLast = max(node.maxLast, child.maxLast)
+		}
+	}
+}
+
+// splitOrderNode splits the tree of node into its first rank nodes and the others
+func splitOrderNode(node *orderNode, rank int) (left, right *orderNode) {
+	if node == nil {
+		return nil, nil
+	}
+	if orderNodeSize(node.left) < rank {
+		node.right, right = splitOrderNode(node.right, rank-orderNodeSize(node.left)-1)
+		pullOrderNode(node)
+		return node, right
+	}
+	left, node.left = splitOrderNode(node.left, rank)
+	pullOrderNode(node)
+	return left, node
+}
+
+// mergeOrderNode joins two trees, the nodes of left are put before those of right
+func mergeOrderNode(left, right *orderNode) *orderNode {
+	switch {
+	case left == nil:
+		return right
+	case right == nil:
+		return left
+	case left.priority > right.priority:
+		left.right = mergeOrderNode(left.right, right)
+		pullOrderNode(left)
+		return left
+	default:
+		right.left = mergeOrderNode(left, right.left)
+		pullOrderNode(right)
+		return right
+	}
+}
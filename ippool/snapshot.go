@@ -0,0 +1,217 @@
+package ippool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/free5gc/util/internal/clock"
+)
+
+// PoolSnapshotVersion is the schema version of marshaled LazyReusePools,
+// it is increased whenever the format changes in an incompatible way
+const PoolSnapshotVersion = 1
+
+// poolSnapshot is the persisted form of a LazyReusePool: its range and its free segments
+// in the order of Dump. Quarantines, leases and the journal are not persisted.
+type poolSnapshot struct {
+	Version  int     `json:"version"`
+	First    int     `json:"first"`
+	Last     int     `json:"last"`
+	Segments [][]int `json:"segments"`
+}
+
+func (p *LazyReusePool) snapshot() poolSnapshot {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	segments := make([][]int, 0)
+	for cur := p.head; cur != nil; cur = cur.next {
+		segments = append(segments, []int{cur.first, cur.last})
+	}
+	return poolSnapshot{
+		Version:  PoolSnapshotVersion,
+		First:    p.first,
+		Last:     p.last,
+		Segments: segments,
+	}
+}
+
+// restore replaces the state of the pool by snapshot. The first segment becomes the head,
+// the others follow it in the order of the snapshot, so the pool allocates like the one dumped.
+func (p *LazyReusePool) restore(snapshot poolSnapshot) error {
+	if snapshot.Version != PoolSnapshotVersion {
+		return errors.Errorf("Unsupported pool snapshot version: %d", snapshot.Version)
+	}
+	if snapshot.First > snapshot.Last {
+		return errors.Errorf("Invalid pool snapshot range: [%d, %d]", snapshot.First, snapshot.Last)
+	}
+
+	ranges := make([]Range, 0, len(snapshot.Segments))
+	for _, seg := range snapshot.Segments {
+		if len(seg) != 2 || seg[0] > seg[1] || seg[0] < snapshot.First || seg[1] > snapshot.Last {
+			return errors.Errorf("Invalid segment in pool snapshot: %v", seg)
+		}
+		ranges = append(ranges, Range{First: seg[0], Last: seg[1]})
+	}
+	sorted := append([]Range(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].First < sorted[j].First })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].First <= sorted[i-1].Last {
+			return errors.Errorf("Overlapped segments in pool snapshot: %s and %s", sorted[i-1], sorted[i])
+		}
+	}
+
+	head, index, order, remain := buildSegments(ranges)
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.journal != nil {
+		return errors.New("Cannot restore a pool snapshot into a journaled pool")
+	}
+	for value := range p.leases {
+		p.cancelLease(value)
+	}
+	if p.leases == nil {
+		p.leases = make(map[int]*lease)
+	}
+	if p.clock == nil {
+		p.clock = clock.Real{}
+	}
+	p.quarantined = quarantineQueue{}
+	if p.tracker != nil {
+		p.tracker.Reset()
+	}
+	// the reservations stay as long as they are not free in the snapshot
+	p.reserved = Difference(Intersection(p.reserved, []Range{{First: snapshot.First, Last: snapshot.Last}}), ranges)
+	p.head = head
+	p.index = index
+	p.order = order
+	p.first = snapshot.First
+	p.last = snapshot.Last
+	p.remain = remain
+	return nil
+}
+
+// buildSegments links the free ranges into segments in their order, ranges[0] becomes the head.
+// The ranges must not be empty or overlap.
+func buildSegments(ranges []Range) (head *segment, index segmentTree, order orderTree, remain int) {
+	if len(ranges) == 0 {
+		return nil, index, order, 0
+	}
+	head = &segment{first: ranges[0].First, last: ranges[0].Last}
+	remain = head.last - head.first + 1
+	prev := head
+	for i, r := range ranges[1:] {
+		remain += r.Last - r.First + 1
+		seg := &segment{first: r.First, last: r.Last}
+		prev.next = seg
+		prev = seg
+		index.insert(seg)
+		order.insert(i, seg)
+	}
+	return head, index, order, remain
+}
+
+// MarshalJSON implements json.Marshaler, the range and free segments of the pool are kept
+func (p *LazyReusePool) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.snapshot())
+}
+
+// UnmarshalJSON implements json.Unmarshaler, the pool is replaced by the snapshot.
+// Pending quarantines, leases and tracked allocations are dropped. It fails if the pool has a journal
+// (see Restore), which would not match the snapshot.
+func (p *LazyReusePool) UnmarshalJSON(data []byte) error {
+	var snapshot poolSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	return p.restore(snapshot)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, the compact form of MarshalJSON
+func (p *LazyReusePool) MarshalBinary() ([]byte, error) {
+	snapshot := p.snapshot()
+
+	data := []byte{byte(snapshot.Version)}
+	data = binary.AppendVarint(data, int64(snapshot.First))
+	data = binary.AppendVarint(data, int64(snapshot.Last))
+	data = binary.AppendUvarint(data, uint64(len(snapshot.Segments)))
+	for _, seg := range snapshot.Segments {
+		data = binary.AppendVarint(data, int64(seg[0]))
+		data = binary.AppendUvarint(data, uint64(seg[1]-seg[0]))
+	}
+	return data, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, see UnmarshalJSON
+func (p *LazyReusePool) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("Empty pool snapshot")
+	}
+	snapshot := poolSnapshot{Version: int(data[0])}
+	data = data[1:]
+
+	var err error
+	readVarint := func() int {
+		value, n := binary.Varint(data)
+		if n <= 0 {
+			err = errors.New("Truncated pool snapshot")
+			return 0
+		}
+		data = data[n:]
+		return int(value)
+	}
+	readUvarint := func() int {
+		value, n := binary.Uvarint(data)
+		if n <= 0 {
+			err = errors.New("Truncated pool snapshot")
+			return 0
+		}
+		data = data[n:]
+		return int(value) // #nosec G115
+	}
+
+	snapshot.First = readVarint()
+	snapshot.Last = readVarint()
+	count := readUvarint()
+	for i := 0; err == nil && i < count; i++ {
+		first := readVarint()
+		length := readUvarint()
+		snapshot.Segments = append(snapshot.Segments, []int{first, first + length})
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		return errors.New("Trailing data in pool snapshot")
+	}
+	return p.restore(snapshot)
+}
+
+// Change is a range of values whose state differs between two pools, see Diff
+type Change struct {
+	Range
+	// Allocated is true if the values are free in the pool before and not in the pool after,
+	// false if they are freed
+	Allocated bool
+}
+
+// Diff returns the ranges of values allocated or freed from before to after in ascending order,
+// e.g. between two snapshots of a pool. Values out of the range of a pool are taken as not free.
+func Diff(before, after *LazyReusePool) []Change {
+	oldFree, newFree := before.freeRanges(), after.freeRanges()
+
+	var changes []Change
+	for _, r := range subtractRanges(oldFree, newFree) {
+		changes = append(changes, Change{Range: r, Allocated: true})
+	}
+	for _, r := range subtractRanges(newFree, oldFree) {
+		changes = append(changes, Change{Range: r, Allocated: false})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].First < changes[j].First })
+	return changes
+}
@@ -0,0 +1,110 @@
+package ippool
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newChurnedPool(t *testing.T) *LazyReusePool {
+	p, err := NewLazyReusePool(10, 100)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		_, ok := p.Allocate()
+		require.True(t, ok)
+	}
+	require.True(t, p.Free(12))
+	require.True(t, p.Free(15))
+	require.True(t, p.Free(16))
+	require.True(t, p.Use(50))
+	require.NoError(t, p.Reserve(90, 95))
+	return p
+}
+
+func TestLazyReusePool_MarshalJSON(t *testing.T) {
+	p := newChurnedPool(t)
+
+	data, err := json.Marshal(p)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"version":1,"first":10,"last":100,"segments":[[30,49],[51,89],[96,100],[12,12],[15,16]]}`,
+		string(data))
+
+	restored := new(LazyReusePool)
+	require.NoError(t, json.Unmarshal(data, restored))
+	assert.Equal(t, p.Dump(), restored.Dump())
+	assert.Equal(t, p.Remain(), restored.Remain())
+	assert.Equal(t, p.Total(), restored.Total())
+	assert.Empty(t, Diff(p, restored))
+
+	// the restored pool keeps working
+	a, ok := restored.Allocate()
+	require.True(t, ok)
+	assert.Equal(t, 30, a)
+	assert.True(t, restored.Free(13))
+	assert.Equal(t, [][]int{{31, 49}, {13, 13}, {51, 89}, {96, 100}, {12, 12}, {15, 16}}, restored.Dump())
+
+	for _, data := range []string{
+		`{"version":2,"first":10,"last":100,"segments":[]}`,
+		`{"version":1,"first":10,"last":100,"segments":[[5,12]]}`,
+		`{"version":1,"first":10,"last":100,"segments":[[10,12],[11,13]]}`,
+		`{"version":1,"first":10,"last":100,"segments":[[12]]}`,
+	} {
+		assert.Error(t, json.Unmarshal([]byte(data), restored), data)
+	}
+}
+
+func TestLazyReusePool_MarshalBinary(t *testing.T) {
+	p := newChurnedPool(t)
+
+	data, err := p.MarshalBinary()
+	require.NoError(t, err)
+
+	restored, err := NewLazyReusePool(0, 0)
+	require.NoError(t, err)
+	require.NoError(t, restored.UnmarshalBinary(data))
+	assert.Equal(t, p.Dump(), restored.Dump())
+	assert.Equal(t, p.Remain(), restored.Remain())
+
+	assert.Error(t, restored.UnmarshalBinary(nil))
+	assert.EqualError(t, restored.UnmarshalBinary(data[:len(data)-1]), "Truncated pool snapshot")
+	assert.EqualError(t, restored.UnmarshalBinary(append(data, 0)), "Trailing data in pool snapshot")
+
+	// the snapshot would not match the journal
+	_, err = restored.Restore(&failingJournal{})
+	require.NoError(t, err)
+	dump := restored.Dump()
+	assert.EqualError(t, restored.UnmarshalBinary(data), "Cannot restore a pool snapshot into a journaled pool")
+	assert.Equal(t, dump, restored.Dump())
+}
+
+func TestDiff(t *testing.T) {
+	old := newChurnedPool(t)
+	data, err := old.MarshalBinary()
+	require.NoError(t, err)
+	p := new(LazyReusePool)
+	require.NoError(t, p.UnmarshalBinary(data))
+
+	for i := 0; i < 3; i++ {
+		_, ok := p.Allocate()
+		require.True(t, ok)
+	}
+	require.True(t, p.Free(20))
+	require.True(t, p.Free(21))
+	require.True(t, p.Use(16))
+	require.True(t, p.Free(50))
+
+	assert.Equal(t, []Change{
+		{Range: Range{First: 16, Last: 16}, Allocated: true},
+		{Range: Range{First: 20, Last: 21}, Allocated: false},
+		{Range: Range{First: 30, Last: 32}, Allocated: true},
+		{Range: Range{First: 50, Last: 50}, Allocated: false},
+	}, Diff(old, p))
+	assert.Equal(t, []Change{
+		{Range: Range{First: 16, Last: 16}, Allocated: false},
+		{Range: Range{First: 20, Last: 21}, Allocated: true},
+		{Range: Range{First: 30, Last: 32}, Allocated: false},
+		{Range: Range{First: 50, Last: 50}, Allocated: true},
+	}, Diff(p, old))
+}
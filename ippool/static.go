@@ -0,0 +1,163 @@
+package ippool
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+
+	"github.com/free5gc/util/validator"
+)
+
+// staticIP is an address reserved for a subscriber, e.g. from the static IP of UDM subscription data
+type staticIP struct {
+	supi  string
+	value int
+	// allocated is true between AllocateStaticIP and ReleaseStaticIP
+	allocated bool
+}
+
+// AddStaticIP reserves ip for the subscriber of supi. The address is taken out of dynamic allocation
+// immediately, so it can only be allocated by AllocateStaticIP of the same SUPI.
+// Like Exclude, static reservations are not journaled and must be added again before Restore.
+func (p *IPPool) AddStaticIP(supi string, ip net.IP) error {
+	if !validator.IsValidSupi(supi) {
+		return errors.Errorf("invalid SUPI: %s", supi)
+	}
+	value, err := p.valueOf(ip)
+	if err != nil {
+		return err
+	}
+
+	p.staticMtx.Lock()
+	defer p.staticMtx.Unlock()
+
+	if static, ok := p.statics[supi]; ok {
+		return errors.Errorf("SUPI %s has a static IP: %s", supi, p.ipOf(static.value))
+	}
+	if static, ok := p.staticOf[value]; ok {
+		return errors.Errorf("IP[%s] is statically reserved for %s", ip, static.supi)
+	}
+	if !p.Pool.reserveValue(value) {
+		return errors.Errorf("IP[%s] is used in Pool[%+v]", ip, p.Subnet())
+	}
+
+	if p.statics == nil {
+		p.statics = make(map[string]*staticIP)
+		p.staticOf = make(map[int]*staticIP)
+	}
+	static := &staticIP{supi: supi, value: value}
+	p.statics[supi] = static
+	p.staticOf[value] = static
+	return nil
+}
+
+// RemoveStaticIP removes the static reservation of supi and returns its address to dynamic allocation,
+// it fails if the address is allocated
+func (p *IPPool) RemoveStaticIP(supi string) error {
+	p.staticMtx.Lock()
+	defer p.staticMtx.Unlock()
+
+	static, ok := p.statics[supi]
+	if !ok {
+		return errors.Errorf("SUPI %s has no static IP", supi)
+	}
+	if static.allocated {
+		return errors.Errorf("static IP[%s] of %s is allocated", p.ipOf(static.value), supi)
+	}
+	delete(p.statics, supi)
+	delete(p.staticOf, static.value)
+	p.Pool.unreserveValue(static.value)
+	return nil
+}
+
+// StaticIP returns the address reserved for supi
+func (p *IPPool) StaticIP(supi string) (net.IP, bool) {
+	p.staticMtx.Lock()
+	defer p.staticMtx.Unlock()
+
+	static, ok := p.statics[supi]
+	if !ok {
+		return nil, false
+	}
+	return p.ipOf(static.value), true
+}
+
+// StaticOwner returns the SUPI which ip is reserved for
+func (p *IPPool) StaticOwner(ip net.IP) (supi string, ok bool) {
+	value, err := p.valueOf(ip)
+	if err != nil {
+		return "", false
+	}
+	return p.staticOwner(value)
+}
+
+func (p *IPPool) staticOwner(value int) (supi string, ok bool) {
+	p.staticMtx.Lock()
+	defer p.staticMtx.Unlock()
+
+	static, ok := p.staticOf[value]
+	if !ok {
+		return "", false
+	}
+	return static.supi, true
+}
+
+// AllocateStaticIP allocates the address reserved for supi
+func (p *IPPool) AllocateStaticIP(supi string) (net.IP, error) {
+	p.staticMtx.Lock()
+	defer p.staticMtx.Unlock()
+
+	static, ok := p.statics[supi]
+	if !ok {
+		return nil, errors.Errorf("SUPI %s has no static IP", supi)
+	}
+	if static.allocated {
+		return nil, errors.Errorf("static IP[%s] of %s is allocated", p.ipOf(static.value), supi)
+	}
+	static.allocated = true
+	return p.ipOf(static.value), nil
+}
+
+// ReleaseStaticIP releases ip allocated by AllocateStaticIP, it fails if ip is not reserved for supi.
+// The address stays reserved for supi.
+func (p *IPPool) ReleaseStaticIP(supi string, ip net.IP) error {
+	value, err := p.valueOf(ip)
+	if err != nil {
+		return errors.Wrapf(err, "failed to release UE Address")
+	}
+
+	p.staticMtx.Lock()
+	defer p.staticMtx.Unlock()
+
+	static, ok := p.staticOf[value]
+	if !ok {
+		return errors.Errorf("failed to release UE Address: %s is not statically reserved", ip)
+	}
+	if static.supi != supi {
+		return errors.Errorf("failed to release UE Address: %s is not reserved for %s", ip, supi)
+	}
+	if !static.allocated {
+		return errors.Errorf("failed to release UE Address: %s", ip)
+	}
+	static.allocated = false
+	return nil
+}
+
+// reserveValue takes value out of the pool like Reserve, it returns false if value is not free
+func (p *LazyReusePool) reserveValue(value int) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.releaseQuarantined()
+	if !p.isFree(value) {
+		return false
+	}
+	return p.use(value)
+}
+
+// unreserveValue returns value taken by reserveValue to the pool
+func (p *LazyReusePool) unreserveValue(value int) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.free(value)
+}
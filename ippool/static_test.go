@@ -0,0 +1,65 @@
+package ippool
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPPool_StaticIP(t *testing.T) {
+	const (
+		supi1 = "imsi-208930000000001"
+		supi2 = "imsi-208930000000002"
+	)
+	pool, err := NewIPPool("10.10.0.0/29")
+	require.NoError(t, err)
+
+	require.EqualError(t, pool.AddStaticIP("imsi-1", net.ParseIP("10.10.0.1")), "invalid SUPI: imsi-1")
+	require.Error(t, pool.AddStaticIP(supi1, net.ParseIP("10.20.0.1")))
+	require.NoError(t, pool.AddStaticIP(supi1, net.ParseIP("10.10.0.1")))
+	require.Error(t, pool.AddStaticIP(supi1, net.ParseIP("10.10.0.2")))
+	require.EqualError(t, pool.AddStaticIP(supi2, net.ParseIP("10.10.0.1")),
+		"IP[10.10.0.1] is statically reserved for "+supi1)
+	assert.Equal(t, 5, pool.Pool.Remain())
+
+	// lookups
+	ip, ok := pool.StaticIP(supi1)
+	require.True(t, ok)
+	assert.Equal(t, "10.10.0.1", ip.String())
+	_, ok = pool.StaticIP(supi2)
+	assert.False(t, ok)
+	owner, ok := pool.StaticOwner(net.ParseIP("10.10.0.1"))
+	require.True(t, ok)
+	assert.Equal(t, supi1, owner)
+
+	// excluded from dynamic allocation
+	ip, err = pool.Allocate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "10.10.0.2", ip.String())
+	_, err = pool.Allocate(net.ParseIP("10.10.0.1"))
+	require.EqualError(t, err, "IP[10.10.0.1] is statically reserved in Pool[10.10.0.0/29]")
+	require.EqualError(t, pool.AddStaticIP(supi2, ip), "IP[10.10.0.2] is used in Pool[10.10.0.0/29]")
+
+	// ownership is checked on release
+	ip, err = pool.AllocateStaticIP(supi1)
+	require.NoError(t, err)
+	assert.Equal(t, "10.10.0.1", ip.String())
+	_, err = pool.AllocateStaticIP(supi1)
+	require.Error(t, err)
+	require.Error(t, pool.Release(ip))
+	require.EqualError(t, pool.ReleaseStaticIP(supi2, ip),
+		"failed to release UE Address: 10.10.0.1 is not reserved for "+supi2)
+	require.Error(t, pool.RemoveStaticIP(supi1))
+	require.NoError(t, pool.ReleaseStaticIP(supi1, ip))
+	require.Error(t, pool.ReleaseStaticIP(supi1, ip))
+
+	// the address returns to dynamic allocation
+	require.NoError(t, pool.RemoveStaticIP(supi1))
+	_, ok = pool.StaticOwner(ip)
+	assert.False(t, ok)
+	assert.Equal(t, 5, pool.Pool.Remain())
+	_, err = pool.Allocate(ip)
+	require.NoError(t, err)
+}
@@ -0,0 +1,170 @@
+// Package leakcheck records the live allocations of ID and address pools
+// and finds the allocations which are likely leaked.
+package leakcheck
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStackDepth is the maximum number of frames captured for an allocation
+const maxStackDepth = 32
+
+// Allocation is a live value recorded by a Tracker
+type Allocation struct {
+	Value int64
+	// Owner is the tag set by Tracker.SetOwner, e.g. a SUPI or a PDU session, empty if not set
+	Owner string
+	Time  time.Time
+	// Stack is the caller stack of the allocation, empty if stacks are not captured
+	Stack string
+}
+
+type record struct {
+	owner string
+	time  time.Time
+	pcs   []uintptr
+}
+
+// Tracker records who allocated each live value of a pool
+type Tracker struct {
+	mtx     sync.Mutex
+	records map[int64]*record
+	// captureStack enables capturing the caller stack of each allocation
+	captureStack bool
+	// internal is the package prefix of frames removed from the top of captured stacks
+	internal string
+	now      func() time.Time
+}
+
+// NewTracker makes a Tracker. If captureStack is true the caller stack of each allocation is recorded,
+// the top frames of the package internal (e.g. "github.com/free5gc/util/ippool.") are omitted.
+func NewTracker(captureStack bool, internal string) *Tracker {
+	return &Tracker{
+		records:      make(map[int64]*record),
+		captureStack: captureStack,
+		internal:     internal,
+		now:          time.Now,
+	}
+}
+
+// SetNow replaces the clock of the Tracker, it is used in tests
+func (t *Tracker) SetNow(now func() time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.now = now
+}
+
+// Track records the allocation of value at now, replacing any record of value
+func (t *Tracker) Track(value int64) {
+	var pcs []uintptr
+	if t.captureStack {
+		pcs = make([]uintptr, maxStackDepth)
+		// skip runtime.Callers and Track
+		pcs = pcs[:runtime.Callers(2, pcs)]
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.records[value] = &record{time: t.now(), pcs: pcs}
+}
+
+// Untrack removes the record of value, e.g. when value is freed
+func (t *Tracker) Untrack(value int64) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	delete(t.records, value)
+}
+
+// Reset removes all records
+func (t *Tracker) Reset() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.records = make(map[int64]*record)
+}
+
+// SetOwner tags the allocation of value with owner, it returns false if value is not tracked
+func (t *Tracker) SetOwner(value int64, owner string) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	r, ok := t.records[value]
+	if !ok {
+		return false
+	}
+	r.owner = owner
+	return true
+}
+
+// Allocations returns all live allocations in ascending order of values
+func (t *Tracker) Allocations() []Allocation {
+	return t.filter(func(Allocation) bool { return true })
+}
+
+// OlderThan returns the allocations made more than age ago
+func (t *Tracker) OlderThan(age time.Duration) []Allocation {
+	t.mtx.Lock()
+	deadline := t.now().Add(-age)
+	t.mtx.Unlock()
+
+	return t.filter(func(allocation Allocation) bool {
+		return allocation.Time.Before(deadline)
+	})
+}
+
+// Orphans returns the allocations whose owner is not alive. Allocations without owner are skipped.
+// alive is called without the lock of the Tracker.
+func (t *Tracker) Orphans(alive func(owner string) bool) []Allocation {
+	var orphans []Allocation
+	for _, allocation := range t.Allocations() {
+		if allocation.Owner != "" && !alive(allocation.Owner) {
+			orphans = append(orphans, allocation)
+		}
+	}
+	return orphans
+}
+
+func (t *Tracker) filter(match func(Allocation) bool) []Allocation {
+	t.mtx.Lock()
+	allocations := make([]Allocation, 0, len(t.records))
+	stacks := make([][]uintptr, 0, len(t.records))
+	for value, r := range t.records {
+		allocation := Allocation{Value: value, Owner: r.owner, Time: r.time}
+		if match(allocation) {
+			allocations = append(allocations, allocation)
+			stacks = append(stacks, r.pcs)
+		}
+	}
+	t.mtx.Unlock()
+
+	for i := range allocations {
+		allocations[i].Stack = t.formatStack(stacks[i])
+	}
+	sort.Slice(allocations, func(i, j int) bool { return allocations[i].Value < allocations[j].Value })
+	return allocations
+}
+
+// formatStack formats pcs like runtime/debug.Stack, without the top frames of the internal package
+func (t *Tracker) formatStack(pcs []uintptr) string {
+	if len(pcs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	top := true
+	for {
+		frame, more := frames.Next()
+		if !top || t.internal == "" || !strings.HasPrefix(frame.Function, t.internal) {
+			top = false
+			fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
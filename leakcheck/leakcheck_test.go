@@ -0,0 +1,55 @@
+package leakcheck
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewTracker(true, "")
+	tracker.SetNow(func() time.Time { return now })
+
+	tracker.Track(3)
+	now = now.Add(time.Minute)
+	tracker.Track(1)
+	tracker.Track(2)
+	tracker.Untrack(2)
+	assert.True(t, tracker.SetOwner(1, "imsi-208930000000001"))
+	assert.True(t, tracker.SetOwner(3, "imsi-208930000000003"))
+	assert.False(t, tracker.SetOwner(2, "imsi-208930000000002"))
+
+	allocations := tracker.Allocations()
+	require.Len(t, allocations, 2)
+	assert.Equal(t, int64(1), allocations[0].Value)
+	assert.Equal(t, "imsi-208930000000001", allocations[0].Owner)
+	assert.Equal(t, now, allocations[0].Time)
+	assert.Contains(t, allocations[0].Stack, "leakcheck.TestTracker")
+
+	now = now.Add(30 * time.Second)
+	old := tracker.OlderThan(time.Minute)
+	require.Len(t, old, 1)
+	assert.Equal(t, int64(3), old[0].Value)
+
+	orphans := tracker.Orphans(func(owner string) bool {
+		return owner == "imsi-208930000000001"
+	})
+	require.Len(t, orphans, 1)
+	assert.Equal(t, int64(3), orphans[0].Value)
+
+	tracker.Reset()
+	assert.Empty(t, tracker.Allocations())
+}
+
+func TestTrackerWithoutStack(t *testing.T) {
+	tracker := NewTracker(false, "")
+	tracker.Track(1)
+	allocations := tracker.Allocations()
+	require.Len(t, allocations, 1)
+	assert.Empty(t, allocations[0].Stack)
+	// allocations without owner are not orphans
+	assert.Empty(t, tracker.Orphans(func(string) bool { return false }))
+}
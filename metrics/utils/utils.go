@@ -18,9 +18,11 @@ const (
 type MetricTypeEnabled string
 
 const (
-	SBI  MetricTypeEnabled = "sbi"
-	NAS  MetricTypeEnabled = "nas"
-	NGAP MetricTypeEnabled = "ngap"
+	SBI    MetricTypeEnabled = "sbi"
+	NAS    MetricTypeEnabled = "nas"
+	NGAP   MetricTypeEnabled = "ngap"
+	FSM    MetricTypeEnabled = "fsm"
+	IPPOOL MetricTypeEnabled = "ippool"
 )
 
 var businessMetricsEnabled bool
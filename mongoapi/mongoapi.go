@@ -441,6 +441,33 @@ func RestfulAPIPostMany(collName string, filter bson.M, postDataArray []interfac
 	return nil
 }
 
+// RestfulAPIFindOneAndUpdate atomically applies update to the first document matching filter in the order
+// of sort (findAndModify), and returns the updated document. If upsert is true, a document is inserted
+// when no document matches. It returns nil if no document matches and upsert is false.
+func RestfulAPIFindOneAndUpdate(collName string, filter bson.M, update bson.M, sort bson.D, upsert bool) (
+	map[string]interface{}, error,
+) {
+	collection := Client.Database(dbName).Collection(collName)
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetUpsert(upsert)
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+
+	var result map[string]interface{}
+	err := collection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("RestfulAPIFindOneAndUpdate err: %+v", err)
+	}
+
+	// Delete "_id" entry which is auto-inserted by MongoDB
+	delete(result, "_id")
+	return result, nil
+}
+
 func RestfulAPICount(collName string, filter bson.M) (int64, error) {
 	collection := Client.Database(dbName).Collection(collName)
 	result, err := collection.CountDocuments(context.TODO(), filter)